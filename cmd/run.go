@@ -0,0 +1,216 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/zbysir/writeflow/internal/model"
+	"github.com/zbysir/writeflow/internal/pkg/config"
+	"github.com/zbysir/writeflow/internal/pkg/db"
+	"github.com/zbysir/writeflow/internal/pkg/signal"
+	"github.com/zbysir/writeflow/internal/repo"
+	"github.com/zbysir/writeflow/pkg/modules/builtin"
+	"github.com/zbysir/writeflow/pkg/modules/llm"
+	"github.com/zbysir/writeflow/pkg/writeflow"
+	"io"
+	"os"
+	"strings"
+)
+
+type runParams struct {
+	File       string   `json:"file"`
+	Id         int64    `json:"id"`
+	VersionId  int64    `json:"version-id"`
+	Data       string   `json:"data"`
+	Param      []string `json:"param"`
+	ParamsFile string   `json:"params-file"`
+	Parallel   int      `json:"parallel"`
+	Executor   string   `json:"executor"`
+	Output     string   `json:"output"`
+}
+
+// Run 在命令行中执行流程，不需要启动 api 服务，用于定时任务或 CI。
+// 每个节点的状态（NodeStatusLog）会作为一行 json 输出到 stdout，输出节点执行失败时返回错误（退出码不为 0）。
+func Run() *cobra.Command {
+	v := viper.New()
+	cmd := &cobra.Command{
+		Use:   "run",
+		Short: "Run a flow from a json file or the local store",
+		Example: `  writeflow run -f flow.json -p question=hello
+  writeflow run --id 1 --params-file params.json
+  echo '{"question": "hello"}' | writeflow run -f flow.json --params-file -`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p, err := config.Get[runParams](v)
+			if err != nil {
+				return err
+			}
+
+			flow, err := loadRunFlow(p)
+			if err != nil {
+				return err
+			}
+			if p.Output != "" {
+				flow.Graph.OutputNodeId = p.Output
+			}
+
+			params, err := loadRunParams(p, cmd.InOrStdin())
+			if err != nil {
+				return err
+			}
+
+			f, err := model.FlowFromModel(flow)
+			if err != nil {
+				return err
+			}
+			// 输出节点不存在时不会有节点被执行
+			if _, ok := f.Nodes[f.OutputNodeId]; !ok {
+				if p.Output != "" {
+					return fmt.Errorf("output node '%s' not found in flow", p.Output)
+				}
+				return fmt.Errorf("flow has no output node '%s', use --output to set one", f.OutputNodeId)
+			}
+
+			// 命令行中没有向量库与子流程（sub_flow）
+			wf := writeflow.NewWriteFlow()
+			wf.RegisterModule(builtin.New())
+			wf.RegisterPlugin(llm.NewLangChain(nil))
+
+			ctx, cancel := signal.NewContext()
+			defer cancel()
+
+			status, err := wf.ExecFlowAsync(ctx, f, params, p.Parallel, writeflow.WithExecutor(p.Executor))
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			var outputErr string
+			for s := range status {
+				bs, err := s.Json()
+				if err != nil {
+					return fmt.Errorf("status to json error: %w", err)
+				}
+				_, err = fmt.Fprintf(out, "%s\n", bs)
+				if err != nil {
+					return err
+				}
+
+				if s.NodeId == f.OutputNodeId {
+					switch s.Status {
+					case writeflow.StatusFailed, writeflow.StatusCancelled:
+						outputErr = s.Error
+					case writeflow.StatusSuccess:
+						outputErr = ""
+					}
+				}
+			}
+
+			if outputErr != "" {
+				return fmt.Errorf("run flow error: %s", outputErr)
+			}
+			return nil
+		},
+	}
+
+	config.DeclareFlag(v, cmd, "file", "f", "", "flow json file, as exported from the web ui")
+	config.DeclareFlag(v, cmd, "id", "", "0", "flow id in the local store, used when file is not set")
+	config.DeclareFlag(v, cmd, "version-id", "", "0", "flow version in the local store, default is the latest saved flow")
+	config.DeclareFlag(v, cmd, "data", "", "./data", "data dir of the local store")
+	config.DeclareFlag(v, cmd, "param", "p", []string{}, "flow param as key=value, can be repeated")
+	config.DeclareFlag(v, cmd, "params-file", "", "", "json file of flow params, '-' to read from stdin")
+	config.DeclareFlag(v, cmd, "parallel", "", "4", "max nodes to run in parallel, 0 runs nodes one by one")
+	config.DeclareFlag(v, cmd, "executor", "", writeflow.ExecutorPull, "executor: pull / topo")
+	config.DeclareFlag(v, cmd, "output", "o", "", "output node id, default is the flow's output node")
+
+	return cmd
+}
+
+func loadRunFlow(p runParams) (*model.Flow, error) {
+	if p.File != "" {
+		bs, err := os.ReadFile(p.File)
+		if err != nil {
+			return nil, err
+		}
+
+		flow := &model.Flow{}
+		err = json.Unmarshal(bs, flow)
+		if err != nil {
+			return nil, fmt.Errorf("parse flow file error: %w", err)
+		}
+		// 也支持只有 graph 的文件
+		if len(flow.Graph.Nodes) == 0 {
+			err = json.Unmarshal(bs, &flow.Graph)
+			if err != nil {
+				return nil, fmt.Errorf("parse flow file error: %w", err)
+			}
+		}
+		if len(flow.Graph.Nodes) == 0 {
+			return nil, fmt.Errorf("flow file '%s' has no nodes", p.File)
+		}
+		return flow, nil
+	}
+
+	if p.Id == 0 {
+		return nil, fmt.Errorf("file or id must be set")
+	}
+
+	kvDb, err := db.NewKvDb(p.Data).Open("db", "default")
+	if err != nil {
+		return nil, err
+	}
+	defer kvDb.Close()
+
+	flowRepo := repo.NewBoltDBFlow(kvDb)
+	if p.VersionId != 0 {
+		v, exist, err := flowRepo.GetFlowVersion(context.Background(), p.Id, p.VersionId)
+		if err != nil {
+			return nil, err
+		}
+		if !exist {
+			return nil, fmt.Errorf("flow %d version %d not exist", p.Id, p.VersionId)
+		}
+		return &v.Flow, nil
+	}
+
+	flow, exist, err := flowRepo.GetFlowById(context.Background(), p.Id)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, fmt.Errorf("flow %d not exist", p.Id)
+	}
+	return flow, nil
+}
+
+// loadRunParams 先读取 params 文件，再使用 --param 覆盖
+func loadRunParams(p runParams, stdin io.Reader) (map[string]interface{}, error) {
+	params := map[string]interface{}{}
+	if p.ParamsFile != "" {
+		var bs []byte
+		var err error
+		if p.ParamsFile == "-" {
+			bs, err = io.ReadAll(stdin)
+		} else {
+			bs, err = os.ReadFile(p.ParamsFile)
+		}
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(bs, &params)
+		if err != nil {
+			return nil, fmt.Errorf("parse params error: %w", err)
+		}
+	}
+
+	for _, kv := range p.Param {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid param '%s', should be key=value", kv)
+		}
+		params[k] = v
+	}
+
+	return params, nil
+}
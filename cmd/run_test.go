@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/zbysir/writeflow/internal/model"
+	"github.com/zbysir/writeflow/pkg/writeflow"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeRunFlow(t *testing.T) string {
+	flow := model.Flow{Graph: model.Graph{Nodes: model.Nodes{
+		{Id: "a", Type: "template_text", Data: writeflow.ComponentData{
+			Source: writeflow.ComponentSource{CmdType: writeflow.BuiltInCmd, BuiltinCmd: "template_text"},
+			InputParams: []writeflow.NodeInputParam{
+				{Key: "mode", InputType: writeflow.NodeInputLiteral, Value: "go"},
+				{Key: "template", InputType: writeflow.NodeInputLiteral, Value: "hi {{.name}}"},
+				{Key: "name", InputType: writeflow.NodeInputLiteral, Value: "bysir"},
+			},
+		}},
+	}}}
+	bs, err := json.Marshal(flow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "flow.json")
+	err = os.WriteFile(file, bs, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func execRun(args ...string) (string, error) {
+	c := Run()
+	var out bytes.Buffer
+	c.SetOut(&out)
+	c.SetErr(&out)
+	c.SetArgs(args)
+	err := c.Execute()
+	return out.String(), err
+}
+
+func TestRunOutput(t *testing.T) {
+	file := writeRunFlow(t)
+
+	out, err := execRun("-f", file, "--output", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	var s writeflow.NodeStatusLog
+	err = json.Unmarshal([]byte(lines[len(lines)-1]), &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "a", s.NodeId)
+	assert.Equal(t, writeflow.StatusSuccess, s.Status)
+	assert.Equal(t, map[string]interface{}{"default": "hi bysir"}, s.Result)
+
+	// 输出节点不存在时返回错误，而不是什么都不执行
+	_, err = execRun("-f", file, "--output", "b")
+	assert.EqualError(t, err, "output node 'b' not found in flow")
+
+	_, err = execRun("-f", file)
+	assert.EqualError(t, err, "flow has no output node 'OUTPUT', use --output to set one")
+}
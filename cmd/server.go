@@ -11,14 +11,16 @@ import (
 	"github.com/zbysir/writeflow/internal/pkg/signal"
 	"github.com/zbysir/writeflow/internal/repo"
 	"github.com/zbysir/writeflow/pkg/modules/llm"
+	"github.com/zbysir/writeflow/pkg/writeflow"
 	"strings"
 )
 
 type ApiParams struct {
-	Address string `json:"address"`
-	Secret  string `json:"secret"`
-	OpenAI  OpenAI `json:"openai"`
-	PGDB    PGDB   `json:"pgdb"`
+	Address           string `json:"address"`
+	Secret            string `json:"secret"`
+	OpenAI            OpenAI `json:"openai"`
+	PGDB              PGDB   `json:"pgdb"`
+	FetchAllowPrivate bool   `json:"fetch-allow-private"`
 }
 
 type OpenAI struct {
@@ -54,6 +56,10 @@ func Api() *cobra.Command {
 				}
 			}
 
+			if p.FetchAllowPrivate {
+				writeflow.DefaultJsHttpClient = writeflow.NewJsHttpClient(true)
+			}
+
 			//gin.SetMode(gin.ReleaseMode)
 			//log.Infof("config: %+v", p)
 
@@ -64,6 +70,7 @@ func Api() *cobra.Command {
 
 			flowRepo := repo.NewBoltDBFlow(kvDb)
 			sysRepo := repo.NewBoltDBSystem(kvDb)
+			runLogRepo := repo.NewBoltDBRunLog(kvDb)
 
 			openAIClient := openai.NewClient(p.OpenAI.APIKey)
 
@@ -79,7 +86,7 @@ func Api() *cobra.Command {
 				return err
 			}
 
-			service, err := apiservice.NewApiService(apiservice.Config{Secret: p.Secret, ListenAddress: p.Address}, flowRepo, sysRepo, documentRepo)
+			service, err := apiservice.NewApiService(apiservice.Config{Secret: p.Secret, ListenAddress: p.Address}, flowRepo, sysRepo, runLogRepo, documentRepo)
 			if err != nil {
 				return err
 			}
@@ -102,6 +109,7 @@ func Api() *cobra.Command {
 	config.DeclareFlag(v, cmd, "pgdb.user", "", "postgres", "db password")
 	config.DeclareFlag(v, cmd, "pgdb.port", "", "5432", "db password")
 	config.DeclareFlag(v, cmd, "openai.apikey", "", "", "db password")
+	config.DeclareFlag(v, cmd, "fetch-allow-private", "", "false", "allow fetch in js scripts to access private and loopback addresses")
 
 	return cmd
 }
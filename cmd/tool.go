@@ -1,12 +1,19 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/zbysir/writeflow/internal/model"
 	"github.com/zbysir/writeflow/internal/pkg/config"
+	"github.com/zbysir/writeflow/internal/pkg/db"
 	"github.com/zbysir/writeflow/internal/pkg/log"
+	"github.com/zbysir/writeflow/internal/repo"
+	"github.com/zbysir/writeflow/internal/usecase"
 	"github.com/zbysir/writeflow/pkg/writeflow"
+	"io"
+	"os"
 )
 
 type toolParams struct {
@@ -21,6 +28,8 @@ func Tool() *cobra.Command {
 	}
 
 	cmd.AddCommand(checkPlugin())
+	cmd.AddCommand(exportFlow())
+	cmd.AddCommand(importFlow())
 	return cmd
 }
 
@@ -75,3 +84,130 @@ func checkPlugin() *cobra.Command {
 
 	return cmd
 }
+
+// newLocalFlowUsecase 使用本地的 BoltDB 创建 usecase，会加载设置中启用的插件
+func newLocalFlowUsecase(data string) (*usecase.Flow, func(), error) {
+	kvDb, err := db.NewKvDb(data).Open("db", "default")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	u, err := usecase.NewFlow(repo.NewBoltDBFlow(kvDb), repo.NewBoltDBSystem(kvDb), repo.NewBoltDBRunLog(kvDb), nil)
+	if err != nil {
+		kvDb.Close()
+		return nil, nil, err
+	}
+	return u, kvDb.Close, nil
+}
+
+func exportFlow() *cobra.Command {
+	v := viper.New()
+
+	type exportFlowParams struct {
+		Id     int64  `json:"id"`
+		Format string `json:"format"`
+		Out    string `json:"out"`
+		Data   string `json:"data"`
+	}
+
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export a flow in the local store as a bundle",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p, err := config.Get[exportFlowParams](v)
+			if err != nil {
+				return err
+			}
+			if p.Id == 0 {
+				return fmt.Errorf("id must be set")
+			}
+
+			u, closeDb, err := newLocalFlowUsecase(p.Data)
+			if err != nil {
+				return err
+			}
+			defer closeDb()
+
+			b, err := u.ExportFlow(context.Background(), p.Id)
+			if err != nil {
+				return err
+			}
+			bs, err := b.Encode(p.Format)
+			if err != nil {
+				return err
+			}
+
+			if p.Out == "" {
+				_, err = cmd.OutOrStdout().Write(bs)
+				return err
+			}
+			return os.WriteFile(p.Out, bs, 0644)
+		},
+	}
+
+	config.DeclareFlag(v, cmd, "id", "", "0", "flow id")
+	config.DeclareFlag(v, cmd, "format", "", model.BundleJson, "bundle format: json / yaml")
+	config.DeclareFlag(v, cmd, "out", "o", "", "output file, default is stdout")
+	config.DeclareFlag(v, cmd, "data", "", "./data", "data dir of the local store")
+
+	return cmd
+}
+
+func importFlow() *cobra.Command {
+	v := viper.New()
+
+	type importFlowParams struct {
+		File string `json:"file"`
+		Data string `json:"data"`
+	}
+
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "Import a flow bundle (json or yaml) into the local store",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p, err := config.Get[importFlowParams](v)
+			if err != nil {
+				return err
+			}
+
+			var bs []byte
+			if p.File == "" || p.File == "-" {
+				bs, err = io.ReadAll(cmd.InOrStdin())
+			} else {
+				bs, err = os.ReadFile(p.File)
+			}
+			if err != nil {
+				return err
+			}
+			b, err := model.DecodeFlowBundle(bs)
+			if err != nil {
+				return err
+			}
+
+			u, closeDb, err := newLocalFlowUsecase(p.Data)
+			if err != nil {
+				return err
+			}
+			defer closeDb()
+
+			r, err := u.ImportFlow(context.Background(), b)
+			if err != nil {
+				return err
+			}
+
+			log.Infof("flow imported, id: %d", r.Id)
+			for _, url := range r.MissingPlugins {
+				log.Warnf("plugin '%s' is not enabled", url)
+			}
+			for _, c := range r.MissingComponents {
+				log.Warnf("node '%s' uses missing component '%s'", c.NodeId, c.Component)
+			}
+			return nil
+		},
+	}
+
+	config.DeclareFlag(v, cmd, "file", "f", "", "bundle file, default is stdin")
+	config.DeclareFlag(v, cmd, "data", "", "./data", "data dir of the local store")
+
+	return cmd
+}
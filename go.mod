@@ -5,11 +5,13 @@ go 1.20
 require (
 	github.com/docker/libkv v0.2.1
 	github.com/dop251/goja v0.0.0-20230605162241-28ee0ee714f3
+	github.com/flosch/pongo2/v6 v6.0.0
 	github.com/gin-gonic/gin v1.9.0
 	github.com/go-git/go-billy/v5 v5.4.1
 	github.com/go-git/go-git/v5 v5.7.0
 	github.com/google/uuid v1.3.0
 	github.com/gorilla/websocket v1.5.0
+	github.com/lib/pq v1.10.9
 	github.com/mitchellh/mapstructure v1.5.0
 	github.com/samber/lo v1.38.1
 	github.com/sashabaranov/go-openai v1.11.2
@@ -23,6 +25,9 @@ require (
 	github.com/zbysir/gojsx v0.4.8
 	github.com/zbysir/writeflow-ui v0.0.0-20230703012236-b79f2b2725d8
 	go.uber.org/zap v1.21.0
+	golang.org/x/text v0.9.0
+	gopkg.in/yaml.v3 v3.0.1
+	xorm.io/xorm v1.3.2
 )
 
 // remove replace if this issue (https://github.com/traefik/yaegi/issues/1571) is fixed
@@ -62,7 +67,6 @@ require (
 	github.com/kevinburke/ssh_config v1.2.0 // indirect
 	github.com/klauspost/cpuid/v2 v2.0.9 // indirect
 	github.com/leodido/go-urn v1.2.1 // indirect
-	github.com/lib/pq v1.10.9 // indirect
 	github.com/magiconair/properties v1.8.7 // indirect
 	github.com/mattn/go-isatty v0.0.17 // indirect
 	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
@@ -94,12 +98,9 @@ require (
 	golang.org/x/image v0.5.0 // indirect
 	golang.org/x/net v0.10.0 // indirect
 	golang.org/x/sys v0.8.0 // indirect
-	golang.org/x/text v0.9.0 // indirect
 	google.golang.org/protobuf v1.28.1 // indirect
 	gopkg.in/ini.v1 v1.67.0 // indirect
 	gopkg.in/warnings.v0 v0.1.2 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 	xorm.io/builder v0.3.11-0.20220531020008-1bd24a7dc978 // indirect
-	xorm.io/xorm v1.3.2 // indirect
 )
@@ -31,6 +31,7 @@ type ApiService struct {
 
 	flowRepo     repo.Flow
 	sysRepo      repo.System
+	runLogRepo   repo.RunLog
 	documentRepo repo.Document
 	flowUsecase  *usecase.Flow
 }
@@ -85,9 +86,13 @@ func NewLLMVectorStoreFactory(documentRepo repo.Document) *LLMVectorStoreFactory
 	return &LLMVectorStoreFactory{documentRepo: documentRepo}
 }
 
-func NewApiService(config Config, flowRepo repo.Flow, sysRepo repo.System,
+func NewApiService(config Config, flowRepo repo.Flow, sysRepo repo.System, runLogRepo repo.RunLog,
 	documentRepo repo.Document) (*ApiService, error) {
-	flow, err := usecase.NewFlow(flowRepo, sysRepo, NewLLMVectorStoreFactory(documentRepo))
+	flow, err := usecase.NewFlow(flowRepo, sysRepo, runLogRepo, NewLLMVectorStoreFactory(documentRepo))
+	if err != nil {
+		return nil, err
+	}
+	err = flow.InterruptRunLogs(context.Background())
 	if err != nil {
 		return nil, err
 	}
@@ -96,6 +101,7 @@ func NewApiService(config Config, flowRepo repo.Flow, sysRepo repo.System,
 		flowRepo:     flowRepo,
 		flowUsecase:  flow,
 		sysRepo:      sysRepo,
+		runLogRepo:   runLogRepo,
 		documentRepo: documentRepo,
 	}, nil
 }
@@ -127,6 +133,9 @@ func Cors() gin.HandlerFunc {
 
 var AuthErr = errors.New("need login")
 
+// errorStatusKey 为 true 时 ErrorHandler 使用 code 作为 http 状态码，否则状态码都是 400，见 appErrorStatus
+const errorStatusKey = "error_status"
+
 func ErrorHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Next()
@@ -135,8 +144,14 @@ func ErrorHandler() gin.HandlerFunc {
 			code := 400
 			if errors.Is(err, AuthErr) {
 				code = 401
+			} else if errors.Is(err, AppNotFoundErr) {
+				code = 404
+			}
+			status := http.StatusBadRequest
+			if c.GetBool(errorStatusKey) {
+				status = code
 			}
-			c.JSON(http.StatusBadRequest, gin.H{
+			c.JSON(status, gin.H{
 				"code": code,
 				"msg":  err.Error(),
 			})
@@ -282,11 +297,16 @@ func (a *ApiService) Run(ctx context.Context, addr string) (err error) {
 		c.JSON(200, "ok")
 	})
 
+	// 需要在 api.Use(Auth) 之前注册，Use 会修改 api 本身
+	a.RegisterAppApi(api)
+
 	apiAuth := api.Use(Auth(a.config.Secret))
 
 	a.RegisterFlow(apiAuth)
 	a.RegisterSys(apiAuth)
+	a.RegisterRunLog(apiAuth)
 	a.RegisterDocument(apiAuth)
+	a.RegisterApp(apiAuth)
 
 	s, err := httpsrv.NewService(addr)
 	if err != nil {
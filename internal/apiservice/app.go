@@ -0,0 +1,221 @@
+package apiservice
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/zbysir/writeflow/internal/model"
+	"net/http"
+	"strings"
+)
+
+type AppReq struct {
+	Slug string `json:"slug" form:"slug"`
+}
+
+type AppKeyReq struct {
+	Slug  string `json:"slug" form:"slug"`
+	Name  string `json:"name" form:"name"`
+	KeyId string `json:"key_id" form:"key_id"`
+}
+
+type AppStreamDelta struct {
+	Key   string `json:"key"`
+	Delta string `json:"delta"`
+}
+
+// RegisterApp 管理发布为 HTTP 接口的流程
+func (a *ApiService) RegisterApp(router gin.IRoutes) {
+	router.GET("/app", func(ctx *gin.Context) {
+		as, err := a.sysRepo.GetApps(ctx)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+		list := make([]model.App, len(as))
+		for i, app := range as {
+			list[i] = app.Public()
+		}
+
+		ctx.JSON(200, list)
+	})
+
+	router.GET("/app_one", func(ctx *gin.Context) {
+		var params AppReq
+		err := ctx.Bind(&params)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+		app, exist, err := a.sysRepo.GetApp(ctx, params.Slug)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+		if !exist {
+			ctx.JSON(404, "not found")
+			return
+		}
+
+		ctx.JSON(200, app.Public())
+	})
+
+	// 创建或更新，不会修改已有的 key
+	router.PUT("/app", func(ctx *gin.Context) {
+		var params model.App
+		err := ctx.Bind(&params)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+		app, err := a.flowUsecase.PublishApp(ctx, &params)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+
+		ctx.JSON(200, app.Public())
+	})
+
+	router.DELETE("/app", func(ctx *gin.Context) {
+		var params AppReq
+		err := ctx.Bind(&params)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+		err = a.sysRepo.DeleteApp(ctx, params.Slug)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+
+		ctx.JSON(200, "ok")
+	})
+
+	// 创建 key，key 只在这里返回一次
+	router.POST("/app/key", func(ctx *gin.Context) {
+		var params AppKeyReq
+		err := ctx.Bind(&params)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+		key, k, err := a.flowUsecase.CreateAppKey(ctx, params.Slug, params.Name)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+
+		ctx.JSON(200, gin.H{
+			"key":  key,
+			"info": k,
+		})
+	})
+
+	router.DELETE("/app/key", func(ctx *gin.Context) {
+		var params AppKeyReq
+		err := ctx.Bind(&params)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+		err = a.flowUsecase.DeleteAppKey(ctx, params.Slug, params.KeyId)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+
+		ctx.JSON(200, "ok")
+	})
+}
+
+var AppNotFoundErr = errors.New("app not found")
+
+// appAuth 使用 App 的 API key 认证，key 放在 Authorization: Bearer <key> 或 X-Api-Key 中
+func (a *ApiService) appAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		app, exist, err := a.sysRepo.GetApp(c, c.Param("slug"))
+		if err != nil {
+			c.Error(err)
+			c.Abort()
+			return
+		}
+		if !exist {
+			c.Error(AppNotFoundErr)
+			c.Abort()
+			return
+		}
+
+		key := c.GetHeader("X-Api-Key")
+		if key == "" {
+			key = strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		}
+		if !app.CheckKey(key) {
+			c.Error(AuthErr)
+			c.Abort()
+			return
+		}
+
+		c.Set("app", app)
+		c.Next()
+	}
+}
+
+// appErrorStatus 让 App 对外的接口返回与错误对应的 http 状态码（如 404、401），调用方通常只检查状态码
+func appErrorStatus(c *gin.Context) {
+	c.Set(errorStatusKey, true)
+	c.Next()
+}
+
+// RegisterAppApi 是 App 对外的接口，不需要登录，使用 App 的 API key 认证
+func (a *ApiService) RegisterAppApi(router gin.IRoutes) {
+	router.GET("/v1/apps/:slug/schema", appErrorStatus, a.appAuth(), func(ctx *gin.Context) {
+		app := ctx.MustGet("app").(*model.App)
+		ctx.JSON(200, app.Schema())
+	})
+
+	// body 为 _params，输出节点中有流时使用 SSE 返回：
+	//  event: delta, data: {"key": "default", "delta": "..."}
+	//  event: done, data: 最终结果
+	//  event: error, data: {"msg": "..."}
+	router.POST("/v1/apps/:slug", appErrorStatus, a.appAuth(), func(ctx *gin.Context) {
+		app := ctx.MustGet("app").(*model.App)
+		params := map[string]interface{}{}
+		if ctx.Request.ContentLength != 0 {
+			err := ctx.BindJSON(&params)
+			if err != nil {
+				return
+			}
+		}
+
+		// 收到第一个 delta 时才切换为 SSE，没有流时和普通接口一样返回 json
+		streaming := false
+		rsp, err := a.flowUsecase.RunApp(ctx.Request.Context(), app, params, func(key string, delta string) {
+			if !streaming {
+				streaming = true
+				ctx.Header("Content-Type", "text/event-stream")
+				ctx.Header("Cache-Control", "no-cache")
+				ctx.Header("X-Accel-Buffering", "no")
+				ctx.Status(http.StatusOK)
+			}
+			ctx.SSEvent("delta", AppStreamDelta{Key: key, Delta: delta})
+			ctx.Writer.Flush()
+		})
+
+		if streaming {
+			if err != nil {
+				ctx.SSEvent("error", gin.H{"msg": err.Error()})
+			} else {
+				ctx.SSEvent("done", rsp)
+			}
+			ctx.Writer.Flush()
+			return
+		}
+
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+		ctx.JSON(200, rsp)
+	})
+}
@@ -1,11 +1,16 @@
 package apiservice
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/zbysir/writeflow/internal/model"
+	"github.com/zbysir/writeflow/internal/pkg/ws"
 	"github.com/zbysir/writeflow/internal/repo"
+	"github.com/zbysir/writeflow/pkg/writeflow"
+	"io"
+	"strconv"
 	"time"
 )
 
@@ -24,8 +29,43 @@ type RunFlowReq struct {
 	Graph        *model.Graph           `json:"graph"`
 	Parallel     int                    `json:"parallel"`
 	OutputNodeId string                 `json:"output_node_id"`
+	Executor     string                 `json:"executor"`   // pull（默认）或 topo
+	VersionId    int64                  `json:"version_id"` // 运行指定的版本，为 0 时运行最新保存的流程，只对 id 生效
 }
 
+type FlowVersionReq struct {
+	Id        int64 `json:"id" form:"id"` // 流程 id
+	VersionId int64 `json:"version_id" form:"version_id"`
+}
+
+type FlowVersionListReq struct {
+	repo.GetFlowListParams
+	Id int64 `json:"id" form:"id"`
+}
+
+type FlowVersionDiffReq struct {
+	Id   int64 `json:"id" form:"id"`
+	From int64 `json:"from" form:"from"` // 为 0 时表示最新保存的流程
+	To   int64 `json:"to" form:"to"`
+}
+
+type ExportFlowReq struct {
+	Id     int64  `json:"id" form:"id"`
+	Format string `json:"format" form:"format"` // json（默认）或 yaml
+}
+
+type CancelRunReq struct {
+	RunId string `json:"run_id" form:"run_id"`
+}
+
+type RunEventsReq struct {
+	RunId       string `json:"run_id" form:"run_id"`
+	LastEventId int    `json:"last_event_id" form:"last_event_id"` // 同 Last-Event-ID header，用于不支持设置 header 的客户端
+}
+
+// sseKeepAlive 是没有新状态时发送注释的间隔，避免代理断开空闲的连接
+var sseKeepAlive = 15 * time.Second
+
 func (a *ApiService) RegisterFlow(router gin.IRoutes) {
 	// 获取所有的 repo
 	router.GET("/flow", func(ctx *gin.Context) {
@@ -135,14 +175,14 @@ func (a *ApiService) RegisterFlow(router gin.IRoutes) {
 		if params.Graph != nil {
 			r, err := a.flowUsecase.RunFlowByDetail(context.Background(), &model.Flow{
 				Graph: *params.Graph,
-			}, params.Params, params.Parallel)
+			}, params.Params, params.Parallel, writeflow.WithExecutor(params.Executor))
 			if err != nil {
 				ctx.Error(err)
 				return
 			}
 			ctx.JSON(200, r)
 		} else {
-			r, err := a.flowUsecase.RunFlow(context.Background(), params.Id, params.Params, params.Parallel)
+			r, err := a.flowUsecase.RunFlow(context.Background(), params.Id, params.VersionId, params.Params, params.Parallel, writeflow.WithExecutor(params.Executor))
 			if err != nil {
 				ctx.Error(err)
 				return
@@ -151,6 +191,266 @@ func (a *ApiService) RegisterFlow(router gin.IRoutes) {
 		}
 	})
 
+	router.POST("/flow/validate", func(ctx *gin.Context) {
+		var params RunFlowReq
+		err := ctx.Bind(&params)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+		var flow *model.Flow
+		if params.Graph != nil {
+			flow = &model.Flow{Graph: *params.Graph}
+		} else if params.Id != 0 {
+			var exist bool
+			flow, exist, err = a.flowRepo.GetFlowById(ctx, params.Id)
+			if err != nil {
+				ctx.Error(err)
+				return
+			}
+			if !exist {
+				ctx.JSON(404, "not found")
+				return
+			}
+		} else {
+			ctx.Error(fmt.Errorf("id or graph must be set"))
+			return
+		}
+
+		problems, err := a.flowUsecase.ValidateFlow(ctx, flow)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+		if problems == nil {
+			problems = []writeflow.FlowProblem{}
+		}
+
+		ctx.JSON(200, problems)
+	})
+
+	// 流程的历史版本，新的在前，不包含 graph
+	router.GET("/flow/versions", func(ctx *gin.Context) {
+		var params FlowVersionListReq
+		err := ctx.Bind(&params)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+		vs, total, err := a.flowRepo.GetFlowVersions(ctx, params.Id, params.GetFlowListParams)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+		if vs == nil {
+			vs = []model.FlowVersion{}
+		}
+
+		ctx.JSON(200, map[string]interface{}{
+			"total": total,
+			"list":  vs,
+		})
+	})
+
+	router.GET("/flow/versions/one", func(ctx *gin.Context) {
+		var params FlowVersionReq
+		err := ctx.Bind(&params)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+		v, exist, err := a.flowRepo.GetFlowVersion(ctx, params.Id, params.VersionId)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+		if !exist {
+			ctx.JSON(404, "not found")
+			return
+		}
+
+		ctx.JSON(200, v)
+	})
+
+	router.GET("/flow/versions/diff", func(ctx *gin.Context) {
+		var params FlowVersionDiffReq
+		err := ctx.Bind(&params)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+		d, err := a.flowUsecase.DiffFlowVersion(ctx, params.Id, params.From, params.To)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+
+		ctx.JSON(200, d)
+	})
+
+	// 恢复为指定版本，会保存为一个新的版本
+	router.POST("/flow/versions/restore", func(ctx *gin.Context) {
+		var params FlowVersionReq
+		err := ctx.Bind(&params)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+		flow, err := a.flowUsecase.RestoreFlowVersion(ctx, params.Id, params.VersionId)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+
+		ctx.JSON(200, flow)
+	})
+
+	// 导出为 bundle，format 为 json（默认）或 yaml
+	router.GET("/flow/export", func(ctx *gin.Context) {
+		var params ExportFlowReq
+		err := ctx.Bind(&params)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+		b, err := a.flowUsecase.ExportFlow(ctx, params.Id)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+		if params.Format == "" {
+			params.Format = model.BundleJson
+		}
+		bs, err := b.Encode(params.Format)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+
+		contentType := "application/json"
+		if params.Format == model.BundleYaml {
+			contentType = "application/yaml"
+		}
+		ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=flow-%d.%s", params.Id, params.Format))
+		ctx.Data(200, contentType, bs)
+	})
+
+	// 导入 bundle，body 为 json 或 yaml
+	router.POST("/flow/import", func(ctx *gin.Context) {
+		bs, err := io.ReadAll(ctx.Request.Body)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+		b, err := model.DecodeFlowBundle(bs)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+		r, err := a.flowUsecase.ImportFlow(ctx, b)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+
+		ctx.JSON(200, r)
+	})
+
+	// 以 SSE 的方式返回运行的状态，和 /ws/:topic 中的消息相同：
+	//  id: 序号, event: status, data: NodeStatusLog
+	//  运行结束时 event: eof
+	// 会先返回历史状态，断开后可以使用 Last-Event-ID 继续接收
+	router.GET("/flow/run/events", func(ctx *gin.Context) {
+		var params RunEventsReq
+		err := ctx.Bind(&params)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+		if params.RunId == "" {
+			ctx.Error(fmt.Errorf("run_id must be set"))
+			return
+		}
+		if id := ctx.GetHeader("Last-Event-ID"); id != "" {
+			params.LastEventId, err = strconv.Atoi(id)
+			if err != nil {
+				ctx.Error(fmt.Errorf("invalid Last-Event-ID: %w", err))
+				return
+			}
+		}
+
+		history, ch, cancel, exist := a.flowUsecase.SubscribeRun(params.RunId, params.LastEventId)
+		if !exist {
+			ctx.JSON(404, "not found")
+			return
+		}
+		defer cancel()
+
+		ctx.Header("Content-Type", "text/event-stream")
+		ctx.Header("Cache-Control", "no-cache")
+		ctx.Header("X-Accel-Buffering", "no")
+		ctx.Status(200)
+
+		// 返回 false 表示运行结束
+		write := func(e ws.Event) bool {
+			if bytes.Equal(e.Body, ws.EOF) {
+				fmt.Fprintf(ctx.Writer, "id: %d\nevent: eof\ndata: EOF\n\n", e.Id)
+				ctx.Writer.Flush()
+				return false
+			}
+			fmt.Fprintf(ctx.Writer, "id: %d\nevent: status\ndata: %s\n\n", e.Id, e.Body)
+			ctx.Writer.Flush()
+			return true
+		}
+
+		for _, e := range history {
+			if !write(e) {
+				return
+			}
+		}
+		ctx.Writer.Flush()
+
+		ticker := time.NewTicker(sseKeepAlive)
+		defer ticker.Stop()
+		for {
+			select {
+			case e, ok := <-ch:
+				// 订阅者处理不及时被断开，客户端会使用 Last-Event-ID 重连
+				if !ok {
+					return
+				}
+				if !write(e) {
+					return
+				}
+			case <-ticker.C:
+				fmt.Fprint(ctx.Writer, ": keep-alive\n\n")
+				ctx.Writer.Flush()
+			case <-ctx.Request.Context().Done():
+				return
+			}
+		}
+	})
+
+	router.POST("/flow/run/cancel", func(ctx *gin.Context) {
+		var params CancelRunReq
+		err := ctx.Bind(&params)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+		if params.RunId == "" {
+			ctx.Error(fmt.Errorf("run_id must be set"))
+			return
+		}
+		err = a.flowUsecase.CancelRun(ctx, params.RunId)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+
+		ctx.JSON(200, "ok")
+	})
+
 	router.POST("/flow/run_sync", func(ctx *gin.Context) {
 		var params RunFlowReq
 		err := ctx.Bind(&params)
@@ -164,9 +464,10 @@ func (a *ApiService) RegisterFlow(router gin.IRoutes) {
 		}
 		start := time.Now()
 		if params.Graph != nil {
-			r, err := a.flowUsecase.RunFlowByDetailSync(context.Background(), &model.Flow{
+			// 同步执行跟随请求的 ctx，客户端断开连接时取消运行
+			r, err := a.flowUsecase.RunFlowByDetailSync(ctx.Request.Context(), &model.Flow{
 				Graph: *params.Graph,
-			}, params.Params, params.Parallel)
+			}, params.Params, params.Parallel, writeflow.WithExecutor(params.Executor))
 			if err != nil {
 				ctx.Error(err)
 				return
@@ -174,7 +475,7 @@ func (a *ApiService) RegisterFlow(router gin.IRoutes) {
 			ctx.Header("x-spend", fmt.Sprintf("%v", time.Since(start)))
 			ctx.JSON(200, r)
 		} else {
-			r, err := a.flowUsecase.RunFlowSync(context.Background(), params.Id, params.Params, params.Parallel, params.OutputNodeId)
+			r, err := a.flowUsecase.RunFlowSync(ctx.Request.Context(), params.Id, params.VersionId, params.Params, params.Parallel, params.OutputNodeId, writeflow.WithExecutor(params.Executor))
 			if err != nil {
 				ctx.Error(err)
 				return
@@ -0,0 +1,69 @@
+package apiservice
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/zbysir/writeflow/internal/repo"
+)
+
+func (a *ApiService) RegisterRunLog(router gin.IRoutes) {
+	router.GET("/run_log", func(ctx *gin.Context) {
+		var params repo.GetRunLogListParams
+		err := ctx.Bind(&params)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+		ls, total, err := a.runLogRepo.GetRunLogList(ctx, params)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+
+		ctx.JSON(200, map[string]interface{}{
+			"total": total,
+			"list":  ls,
+		})
+	})
+
+	router.GET("/run_log_one", func(ctx *gin.Context) {
+		var params IdReq
+		err := ctx.Bind(&params)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+		l, exist, err := a.runLogRepo.GetRunLogById(ctx, params.Id)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+		if !exist {
+			ctx.JSON(404, "not found")
+			return
+		}
+
+		ctx.JSON(200, l)
+	})
+
+	router.DELETE("/run_log", func(ctx *gin.Context) {
+		var params IdReq
+		err := ctx.Bind(&params)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+		if params.Id != 0 {
+			params.Ids = append(params.Ids, params.Id)
+		}
+
+		for _, id := range params.Ids {
+			err = a.runLogRepo.DeleteRunLog(ctx, id)
+			if err != nil {
+				ctx.Error(err)
+				return
+			}
+		}
+
+		ctx.JSON(200, "ok")
+	})
+}
@@ -3,6 +3,7 @@ package apiservice
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/zbysir/writeflow/internal/model"
+	"github.com/zbysir/writeflow/pkg/writeflow"
 )
 
 func (a *ApiService) RegisterSys(router gin.IRoutes) {
@@ -25,6 +26,10 @@ func (a *ApiService) RegisterSys(router gin.IRoutes) {
 	router.GET("/system/plugin_status", func(ctx *gin.Context) {
 		ctx.JSON(200, a.flowUsecase.PluginStatus)
 	})
+	// 脚本编译缓存的命中情况
+	router.GET("/system/cmd_cache", func(ctx *gin.Context) {
+		ctx.JSON(200, writeflow.DefaultCmdCache.Stats())
+	})
 	router.PUT("/system/setting", func(ctx *gin.Context) {
 		var params model.Setting
 		err := ctx.Bind(&params)
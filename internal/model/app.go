@@ -0,0 +1,176 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"github.com/zbysir/writeflow/pkg/writeflow"
+	"regexp"
+	"time"
+)
+
+// App 是发布为 HTTP 接口的流程，通过 POST /api/v1/apps/<slug> 调用，使用 App 自己的 API key 认证
+type App struct {
+	Slug        string     `json:"slug"`
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	FlowId      int64      `json:"flow_id"`
+	VersionId   int64      `json:"version_id,omitempty"` // 运行指定的版本，为 0 时运行最新保存的流程
+	Params      []AppParam `json:"params"`               // _params 的定义，用于生成 json schema 与检查参数
+	Output      string     `json:"output,omitempty"`     // 只返回输出节点的这个 key，为空时返回整个输出
+	Keys        []AppKey   `json:"keys"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+type AppParam struct {
+	Key         string `json:"key"`
+	Type        string `json:"type"` // 同组件的类型，如 string / number / []string，见 writeflow.ParsePortType
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required,omitempty"`
+}
+
+// AppKey 是 App 的 API key，只保存 hash，key 只在创建时返回一次
+type AppKey struct {
+	Id        string    `json:"id"`
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash,omitempty"`
+	Prefix    string    `json:"prefix"` // key 的前几位，用于区分不同的 key
+	CreatedAt time.Time `json:"created_at"`
+}
+
+var appSlugReg = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+func (a *App) Check() error {
+	if !appSlugReg.MatchString(a.Slug) {
+		return fmt.Errorf("invalid slug '%s', should match %s", a.Slug, appSlugReg.String())
+	}
+	if a.FlowId == 0 {
+		return fmt.Errorf("flow_id must be set")
+	}
+	for _, p := range a.Params {
+		if p.Key == "" {
+			return fmt.Errorf("param key must be set")
+		}
+	}
+	return nil
+}
+
+// Public 返回不包含 key hash 的 App，用于返回给前端
+func (a App) Public() App {
+	keys := make([]AppKey, len(a.Keys))
+	for i, k := range a.Keys {
+		k.Hash = ""
+		keys[i] = k
+	}
+	a.Keys = keys
+	return a
+}
+
+func hashAppKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+// NewAppKey 生成一个新的 API key，返回 key 的明文与需要保存的 AppKey
+func NewAppKey(name string) (key string, k AppKey, err error) {
+	bs := make([]byte, 24)
+	_, err = rand.Read(bs)
+	if err != nil {
+		return "", k, err
+	}
+	key = "wf-" + hex.EncodeToString(bs)
+
+	return key, AppKey{
+		Id:        hex.EncodeToString(bs[:4]),
+		Name:      name,
+		Hash:      hashAppKey(key),
+		Prefix:    key[:9],
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// CheckKey 返回 key 是否是 App 的 API key
+func (a *App) CheckKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	h := hashAppKey(key)
+	ok := false
+	for _, k := range a.Keys {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(k.Hash)) == 1 {
+			ok = true
+		}
+	}
+	return ok
+}
+
+// jsonSchema 返回类型对应的 json schema，any 与 handle 类型不限制
+func jsonSchema(t writeflow.PortType) map[string]interface{} {
+	switch t.Kind {
+	case writeflow.PortString:
+		return map[string]interface{}{"type": "string"}
+	case writeflow.PortNumber:
+		return map[string]interface{}{"type": "number"}
+	case writeflow.PortInt:
+		return map[string]interface{}{"type": "integer"}
+	case writeflow.PortBool:
+		return map[string]interface{}{"type": "boolean"}
+	case writeflow.PortObject:
+		return map[string]interface{}{"type": "object"}
+	case writeflow.PortList:
+		return map[string]interface{}{"type": "array", "items": jsonSchema(*t.Elem)}
+	}
+	return map[string]interface{}{}
+}
+
+// Schema 返回 _params 的 json schema
+func (a *App) Schema() map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	for _, p := range a.Params {
+		s := jsonSchema(writeflow.ParsePortType(p.Type))
+		if p.Description != "" {
+			s["description"] = p.Description
+		}
+		properties[p.Key] = s
+		if p.Required {
+			required = append(required, p.Key)
+		}
+	}
+
+	return map[string]interface{}{
+		"$schema":    "https://json-schema.org/draft/2020-12/schema",
+		"title":      a.Name,
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
+
+// CheckParams 检查必填的参数，并将参数转换为定义的类型，没有定义的参数原样保留
+func (a *App) CheckParams(params map[string]interface{}) (map[string]interface{}, error) {
+	ps := map[string]interface{}{}
+	for k, v := range params {
+		ps[k] = v
+	}
+
+	for _, p := range a.Params {
+		v, ok := ps[p.Key]
+		if !ok || v == nil {
+			if p.Required {
+				return nil, fmt.Errorf("param '%s' is required", p.Key)
+			}
+			continue
+		}
+
+		v, err := writeflow.ParsePortType(p.Type).Coerce(v)
+		if err != nil {
+			return nil, fmt.Errorf("param '%s': %w", p.Key, err)
+		}
+		ps[p.Key] = v
+	}
+	return ps, nil
+}
@@ -0,0 +1,50 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestApp(t *testing.T) {
+	a := &App{
+		Slug:   "summary",
+		Name:   "Summary",
+		FlowId: 1,
+		Params: []AppParam{
+			{Key: "text", Type: "string", Required: true, Description: "text to summarize"},
+			{Key: "max", Type: "int"},
+			{Key: "tags", Type: "[]string"},
+			{Key: "llm", Type: "LLM"},
+		},
+	}
+	assert.NoError(t, a.Check())
+	assert.Error(t, (&App{Slug: "Bad Slug", FlowId: 1}).Check())
+
+	s := a.Schema()
+	assert.Equal(t, []string{"text"}, s["required"])
+	assert.Equal(t, map[string]interface{}{
+		"text": map[string]interface{}{"type": "string", "description": "text to summarize"},
+		"max":  map[string]interface{}{"type": "integer"},
+		"tags": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+		"llm":  map[string]interface{}{},
+	}, s["properties"])
+
+	ps, err := a.CheckParams(map[string]interface{}{"text": "hi", "max": "10", "other": 1})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"text": "hi", "max": 10, "other": 1}, ps)
+
+	_, err = a.CheckParams(map[string]interface{}{"max": 1})
+	assert.EqualError(t, err, "param 'text' is required")
+
+	key, k, err := NewAppKey("test")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(key, k.Prefix))
+	assert.False(t, a.CheckKey(key))
+	a.Keys = append(a.Keys, k)
+	assert.True(t, a.CheckKey(key))
+	assert.False(t, a.CheckKey(key+"x"))
+	assert.False(t, a.CheckKey(""))
+	assert.Equal(t, "", a.Public().Keys[0].Hash)
+	assert.NotEqual(t, "", a.Keys[0].Hash)
+}
@@ -0,0 +1,98 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"time"
+)
+
+// FlowBundleVersion 是当前导出的 bundle 格式版本，格式不兼容地修改时需要增加
+const FlowBundleVersion = 1
+
+// FlowBundle 是可以在不同实例之间迁移的流程，导出为 json 或 yaml
+type FlowBundle struct {
+	Version     int       `json:"version"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Graph       Graph     `json:"graph"`
+	Plugins     []string  `json:"plugins,omitempty"` // 流程用到的插件地址，导入前需要先安装
+	ExportedAt  time.Time `json:"exported_at"`
+}
+
+type BundleFormat = string
+
+const (
+	BundleJson BundleFormat = "json"
+	BundleYaml BundleFormat = "yaml"
+)
+
+func NewFlowBundle(f *Flow, plugins []string) *FlowBundle {
+	return &FlowBundle{
+		Version:     FlowBundleVersion,
+		Name:        f.Name,
+		Description: f.Description,
+		Graph:       f.Graph,
+		Plugins:     plugins,
+		ExportedAt:  time.Now(),
+	}
+}
+
+// Flow 返回导入时需要创建的流程
+func (b *FlowBundle) Flow() *Flow {
+	return &Flow{
+		Name:        b.Name,
+		Description: b.Description,
+		Graph:       b.Graph,
+	}
+}
+
+// Encode 编码 bundle，yaml 的字段名与 json 相同
+func (b *FlowBundle) Encode(format BundleFormat) ([]byte, error) {
+	switch format {
+	case "", BundleJson:
+		return json.MarshalIndent(b, "", "  ")
+	case BundleYaml:
+		// 先转为 json 再转为 yaml，这样可以复用 json tag
+		bs, err := json.Marshal(b)
+		if err != nil {
+			return nil, err
+		}
+		var v interface{}
+		err = json.Unmarshal(bs, &v)
+		if err != nil {
+			return nil, err
+		}
+		return yaml.Marshal(v)
+	default:
+		return nil, fmt.Errorf("unsupported bundle format '%s', should be json or yaml", format)
+	}
+}
+
+// DecodeFlowBundle 解码 json 或 yaml 格式的 bundle，会检查版本
+func DecodeFlowBundle(bs []byte) (*FlowBundle, error) {
+	bs = bytes.TrimSpace(bs)
+	if !bytes.HasPrefix(bs, []byte("{")) {
+		var v interface{}
+		err := yaml.Unmarshal(bs, &v)
+		if err != nil {
+			return nil, fmt.Errorf("decode bundle error: %w", err)
+		}
+		bs, err = json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("decode bundle error: %w", err)
+		}
+	}
+
+	b := &FlowBundle{}
+	err := json.Unmarshal(bs, b)
+	if err != nil {
+		return nil, fmt.Errorf("decode bundle error: %w", err)
+	}
+
+	if b.Version <= 0 || b.Version > FlowBundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d, supported version is %d", b.Version, FlowBundleVersion)
+	}
+	return b, nil
+}
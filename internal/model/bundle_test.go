@@ -0,0 +1,37 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/zbysir/writeflow/pkg/writeflow"
+	"testing"
+)
+
+func TestFlowBundle(t *testing.T) {
+	f := &Flow{
+		Name: "test",
+		Graph: Graph{
+			Nodes: Nodes{
+				{Id: "a", Type: "template_text", Data: writeflow.ComponentData{Source: writeflow.ComponentSource{CmdType: writeflow.BuiltInCmd, BuiltinCmd: "template_text"}}},
+				{Id: "b", Type: "go_script", Data: writeflow.ComponentData{Source: writeflow.ComponentSource{CmdType: writeflow.GoScriptCmd}}},
+				{Id: "c", Type: "template_text"},
+			},
+			OutputNodeId: "a",
+		},
+	}
+	assert.Equal(t, []string{"template_text"}, f.UsedComponents())
+
+	b := NewFlowBundle(f, []string{"github.com/a/b"})
+	for _, format := range []BundleFormat{BundleJson, BundleYaml} {
+		bs, err := b.Encode(format)
+		assert.NoError(t, err)
+
+		d, err := DecodeFlowBundle(bs)
+		assert.NoError(t, err, format)
+		assert.Equal(t, b.Graph, d.Graph, format)
+		assert.Equal(t, b.Plugins, d.Plugins, format)
+		assert.Equal(t, "test", d.Flow().Name)
+	}
+
+	_, err := DecodeFlowBundle([]byte(`{"version": 2}`))
+	assert.EqualError(t, err, "unsupported bundle version 2, supported version is 1")
+}
@@ -15,6 +15,7 @@ type Flow struct {
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
 	Graph       Graph     `json:"graph"`
+	Version     int64     `json:"version,omitempty"` // 最新的版本号，见 FlowVersion
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
@@ -0,0 +1,98 @@
+package model
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// FlowVersion 是流程的一个不可修改的版本，每次保存流程都会追加一个新的版本
+type FlowVersion struct {
+	Id        int64     `json:"id"` // 版本号，同一个流程中从 1 开始递增
+	FlowId    int64     `json:"flow_id"`
+	Flow      Flow      `json:"flow"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type NodeDiffType = string
+
+const (
+	NodeAdded   NodeDiffType = "added"
+	NodeRemoved NodeDiffType = "removed"
+	NodeChanged NodeDiffType = "changed"
+)
+
+type NodeDiff struct {
+	NodeId string       `json:"node_id"`
+	Type   NodeDiffType `json:"type"`
+	Fields []string     `json:"fields,omitempty"` // changed 时变化的字段：type / position / source / inputs / outputs / data
+}
+
+// FlowDiff 是两个版本之间的差异
+type FlowDiff struct {
+	Fields []string   `json:"fields"` // 流程本身变化的字段：name / description / output_node_id
+	Nodes  []NodeDiff `json:"nodes"`
+}
+
+func jsonEqual(a, b interface{}) bool {
+	ba, _ := json.Marshal(a)
+	bb, _ := json.Marshal(b)
+	return string(ba) == string(bb)
+}
+
+// DiffFlow 比较流程 a 到 b 的变化，节点按 id 排序
+func DiffFlow(a, b *Flow) FlowDiff {
+	d := FlowDiff{Fields: []string{}, Nodes: []NodeDiff{}}
+	if a.Name != b.Name {
+		d.Fields = append(d.Fields, "name")
+	}
+	if a.Description != b.Description {
+		d.Fields = append(d.Fields, "description")
+	}
+	if a.Graph.OutputNodeId != b.Graph.OutputNodeId {
+		d.Fields = append(d.Fields, "output_node_id")
+	}
+
+	for _, bn := range b.Graph.Nodes {
+		an, ok := a.Graph.Nodes.FindById(bn.Id)
+		if !ok {
+			d.Nodes = append(d.Nodes, NodeDiff{NodeId: bn.Id, Type: NodeAdded})
+			continue
+		}
+
+		var fields []string
+		if an.Type != bn.Type {
+			fields = append(fields, "type")
+		}
+		if an.Position != bn.Position || an.Width != bn.Width || an.Height != bn.Height {
+			fields = append(fields, "position")
+		}
+		if !jsonEqual(an.Data.Source, bn.Data.Source) {
+			fields = append(fields, "source")
+		}
+		if !jsonEqual(an.Data.InputParams, bn.Data.InputParams) {
+			fields = append(fields, "inputs")
+		}
+		if !jsonEqual(an.Data.OutputAnchors, bn.Data.OutputAnchors) {
+			fields = append(fields, "outputs")
+		}
+		ad, bd := an.Data, bn.Data
+		ad.Source, ad.InputParams, ad.OutputAnchors = bd.Source, bd.InputParams, bd.OutputAnchors
+		if !jsonEqual(ad, bd) {
+			fields = append(fields, "data")
+		}
+		if len(fields) != 0 {
+			d.Nodes = append(d.Nodes, NodeDiff{NodeId: bn.Id, Type: NodeChanged, Fields: fields})
+		}
+	}
+	for _, an := range a.Graph.Nodes {
+		if _, ok := b.Graph.Nodes.FindById(an.Id); !ok {
+			d.Nodes = append(d.Nodes, NodeDiff{NodeId: an.Id, Type: NodeRemoved})
+		}
+	}
+
+	sort.Slice(d.Nodes, func(i, j int) bool {
+		return d.Nodes[i].NodeId < d.Nodes[j].NodeId
+	})
+	return d
+}
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/zbysir/writeflow/pkg/writeflow"
+	"testing"
+)
+
+func TestDiffFlow(t *testing.T) {
+	a := &Flow{
+		Name: "a",
+		Graph: Graph{Nodes: Nodes{
+			{Id: "1", Type: "template_text"},
+			{Id: "2", Type: "output"},
+			{Id: "3", Type: "output"},
+		}},
+	}
+	b := &Flow{
+		Name: "b",
+		Graph: Graph{Nodes: Nodes{
+			{Id: "1", Type: "template_text", Position: NodePosition{X: 1}, Data: writeflow.ComponentData{
+				InputParams: []writeflow.NodeInputParam{{Key: "template", Value: "hi"}},
+			}},
+			{Id: "2", Type: "output"},
+			{Id: "4", Type: "output"},
+		}},
+	}
+
+	assert.Equal(t, FlowDiff{
+		Fields: []string{"name"},
+		Nodes: []NodeDiff{
+			{NodeId: "1", Type: NodeChanged, Fields: []string{"position", "inputs"}},
+			{NodeId: "3", Type: NodeRemoved},
+			{NodeId: "4", Type: NodeAdded},
+		},
+	}, DiffFlow(a, b))
+}
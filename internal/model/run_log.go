@@ -6,9 +6,72 @@ import (
 )
 
 type RunLog struct {
-	Id       int64                     `json:"id"`
-	FlowId   int64                     `json:"flow_id"`
-	Status   writeflow.NodeStatus      `json:"status"`
-	Result   []writeflow.NodeStatusLog `json:"result"` // save all node run result, update each node run result update.
-	CreateAt time.Time                 `json:"create_at"`
+	Id           int64                     `json:"id"`
+	RunId        string                    `json:"run_id"` // 与 ws topic 相同，如 flow.{uuid}
+	FlowId       int64                     `json:"flow_id"`
+	OutputNodeId string                    `json:"output_node_id,omitempty"` // 流程的状态由输出节点的状态决定
+	Status       writeflow.NodeStatus      `json:"status"`
+	Result       []writeflow.NodeStatusLog `json:"result"` // save all node run result, update each node run result update.
+	CreateAt     time.Time                 `json:"create_at"`
+	EndAt        time.Time                 `json:"end_at,omitempty"`
+}
+
+// SetNodeStatus 更新节点的运行结果，同一个节点只保留最后一次状态。
+func (r *RunLog) SetNodeStatus(s writeflow.NodeStatusLog) {
+	for i, v := range r.Result {
+		if v.NodeId == s.NodeId {
+			r.Result[i] = s
+			return
+		}
+	}
+
+	r.Result = append(r.Result, s)
+}
+
+// Finish 根据输出节点的状态计算整个流程的状态，被处理的失败（如 _try 捕获的错误、_race 中落后的分支）不影响流程的状态。
+// 输出节点没有结束（如上游失败没有执行到）时根据所有节点的状态计算。
+func (r *RunLog) Finish(endAt time.Time) {
+	r.EndAt = endAt
+	for _, v := range r.Result {
+		if v.NodeId != r.OutputNodeId {
+			continue
+		}
+		switch v.Status {
+		case writeflow.StatusFailed, writeflow.StatusCancelled:
+			r.Status = v.Status
+			return
+		case writeflow.StatusSuccess, writeflow.StatusUnreachable:
+			r.Status = writeflow.StatusSuccess
+			return
+		}
+	}
+
+	r.Status = writeflow.StatusSuccess
+	for _, v := range r.Result {
+		switch v.Status {
+		case writeflow.StatusCancelled:
+			r.Status = writeflow.StatusCancelled
+			return
+		case writeflow.StatusFailed:
+			r.Status = writeflow.StatusFailed
+		}
+	}
+}
+
+// Interrupt 标记没有正常结束的运行（如进程退出），还在运行的节点标记为失败
+func (r *RunLog) Interrupt(endAt time.Time) {
+	for i, v := range r.Result {
+		if v.Status == writeflow.StatusRunning || v.Status == writeflow.StatusPending {
+			r.Result[i].Status = writeflow.StatusFailed
+			r.Result[i].Error = "interrupted"
+		}
+	}
+	r.EndAt = endAt
+	r.Status = writeflow.StatusFailed
+}
+
+// Summary 返回不包含节点结果的运行记录，用于列表
+func (r RunLog) Summary() RunLog {
+	r.Result = nil
+	return r
 }
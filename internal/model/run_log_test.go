@@ -0,0 +1,56 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/zbysir/writeflow/pkg/writeflow"
+	"testing"
+	"time"
+)
+
+func TestRunLogFinish(t *testing.T) {
+	cases := []struct {
+		name   string
+		result []writeflow.NodeStatusLog
+		status writeflow.NodeStatus
+	}{
+		{
+			// _try 捕获了 a 的错误
+			name: "handled",
+			result: []writeflow.NodeStatusLog{
+				{NodeId: "a", Status: writeflow.StatusFailed},
+				{NodeId: "try", Status: writeflow.StatusSuccess},
+				{NodeId: "OUTPUT", Status: writeflow.StatusSuccess},
+			},
+			status: writeflow.StatusSuccess,
+		},
+		{
+			name: "output failed",
+			result: []writeflow.NodeStatusLog{
+				{NodeId: "a", Status: writeflow.StatusSuccess},
+				{NodeId: "OUTPUT", Status: writeflow.StatusFailed},
+			},
+			status: writeflow.StatusFailed,
+		},
+		{
+			// 输出节点没有执行到
+			name: "output pending",
+			result: []writeflow.NodeStatusLog{
+				{NodeId: "a", Status: writeflow.StatusFailed},
+				{NodeId: "OUTPUT", Status: writeflow.StatusPending},
+			},
+			status: writeflow.StatusFailed,
+		},
+		{
+			name: "cancelled",
+			result: []writeflow.NodeStatusLog{
+				{NodeId: "a", Status: writeflow.StatusCancelled},
+			},
+			status: writeflow.StatusCancelled,
+		},
+	}
+	for _, c := range cases {
+		l := RunLog{OutputNodeId: "OUTPUT", Result: c.result}
+		l.Finish(time.Now())
+		assert.Equal(t, c.status, l.Status, c.name)
+	}
+}
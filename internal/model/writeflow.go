@@ -2,10 +2,26 @@ package model
 
 import (
 	"fmt"
+	"github.com/spf13/cast"
 	"github.com/zbysir/writeflow/pkg/writeflow"
+	"sort"
+	"time"
 )
 
+// FlowFromModel 转为可以运行的流程，会检查连线的类型
 func FlowFromModel(m *Flow) (*writeflow.Flow, error) {
+	f, err := BuildFlow(m)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.CheckTypes(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// BuildFlow 同 FlowFromModel 但不检查连线的类型，用于 Validate 一次返回所有的类型问题
+func BuildFlow(m *Flow) (*writeflow.Flow, error) {
 	nodes := map[string]writeflow.Node{}
 
 	for _, node := range m.Graph.Nodes {
@@ -17,6 +33,7 @@ func FlowFromModel(m *Flow) (*writeflow.Flow, error) {
 				inputs = append(inputs, writeflow.NodeInput{
 					Key:      input.Key,
 					Type:     writeflow.NodeInputAnchor,
+					DataType: input.Type,
 					Literal:  "",
 					Anchors:  anchors,
 					List:     list,
@@ -26,17 +43,16 @@ func FlowFromModel(m *Flow) (*writeflow.Flow, error) {
 				inputs = append(inputs, writeflow.NodeInput{
 					Key:      input.Key,
 					Type:     writeflow.NodeInputLiteral,
+					DataType: input.Type,
 					Literal:  node.Data.GetInputValue(input.Key),
 					Required: !input.Optional,
 				})
 			}
 		}
 
-		cmdName := node.Type
+		cmdName := NodeCmd(node)
 		var cmder writeflow.CMDer
 		switch node.Data.Source.CmdType {
-		case writeflow.NothingCmd:
-			cmdName = string(writeflow.NothingCmd)
 		case writeflow.GoScriptCmd:
 			var script string
 			if node.Data.Source.Script.Source != "" {
@@ -46,7 +62,9 @@ func FlowFromModel(m *Flow) (*writeflow.Flow, error) {
 			}
 
 			var err error
-			cmder, err = writeflow.NewGoScriptCMD(nil, "", script)
+			cmder, err = writeflow.DefaultCmdCache.Get(writeflow.CmdCacheKey("go_script", script), func() (writeflow.CMDer, error) {
+				return writeflow.NewGoScriptCMD(nil, "", script)
+			})
 			if err != nil {
 				return nil, writeflow.NewExecNodeError(fmt.Errorf("parse script error: %v", err), node.Id)
 			}
@@ -58,13 +76,36 @@ func FlowFromModel(m *Flow) (*writeflow.Flow, error) {
 				script = node.Data.GetInputValue("script").(string)
 			}
 
+			var ops []writeflow.JavaScriptOption
+			size := cast.ToInt(node.Data.GetInputValue("max_call_stack_size"))
+			if size > 0 {
+				ops = append(ops, writeflow.WithJsMaxCallStackSize(size))
+			}
+			timeout := cast.ToInt(node.Data.GetInputValue("timeout"))
+			if timeout > 0 {
+				ops = append(ops, writeflow.WithJsTimeout(time.Duration(timeout)*time.Second))
+			}
+
 			var err error
-			cmder, err = writeflow.NewJavaScriptCMD(script)
+			cmder, err = writeflow.DefaultCmdCache.Get(writeflow.CmdCacheKey("js_script", cast.ToString(size), cast.ToString(timeout), script), func() (writeflow.CMDer, error) {
+				return writeflow.NewJavaScriptCMD(script, ops...)
+			})
 			if err != nil {
 				return nil, writeflow.NewExecNodeError(fmt.Errorf("parse script error: %v", err), node.Id)
 			}
-		case writeflow.BuiltInCmd:
-			cmdName = node.Data.Source.BuiltinCmd
+		}
+
+		// 动态输出的组件无法确定输出
+		var outputs writeflow.NodeOutputs
+		if !node.Data.DynamicOutput && len(node.Data.OutputAnchors) != 0 {
+			outputs = writeflow.NodeOutputs{}
+			for _, o := range node.Data.OutputAnchors {
+				t := o.Type
+				if o.List {
+					t = "[]" + t
+				}
+				outputs = append(outputs, writeflow.NodeOutput{Key: o.Key, Type: t})
+			}
 		}
 
 		nodes[node.Id] = writeflow.Node{
@@ -72,6 +113,7 @@ func FlowFromModel(m *Flow) (*writeflow.Flow, error) {
 			Cmd:      cmdName,
 			BuiltCmd: cmder,
 			Inputs:   inputs,
+			Outputs:  outputs,
 		}
 	}
 	return &writeflow.Flow{
@@ -79,3 +121,29 @@ func FlowFromModel(m *Flow) (*writeflow.Flow, error) {
 		OutputNodeId: m.Graph.GetOutputNodeId(),
 	}, nil
 }
+
+// NodeCmd 返回节点执行的 cmd 名字，脚本节点（go_script / js_script）不使用注册的 cmd
+func NodeCmd(node Node) string {
+	switch node.Data.Source.CmdType {
+	case writeflow.NothingCmd:
+		return string(writeflow.NothingCmd)
+	case writeflow.BuiltInCmd:
+		return node.Data.Source.BuiltinCmd
+	}
+	return node.Type
+}
+
+// UsedComponents 返回流程使用的 cmd，与 FlowFromModel 不同，不会编译脚本
+func (m *Flow) UsedComponents() []string {
+	nodes := map[string]writeflow.Node{}
+	for _, node := range m.Graph.Nodes {
+		switch node.Data.Source.CmdType {
+		case writeflow.GoScriptCmd, writeflow.JavaScriptCmd:
+			continue
+		}
+		nodes[node.Id] = writeflow.Node{Id: node.Id, Cmd: NodeCmd(node)}
+	}
+	cmds := (&writeflow.Flow{Nodes: nodes}).UsedComponents()
+	sort.Strings(cmds)
+	return cmds
+}
@@ -13,6 +13,8 @@ func DeclareFlag(v *viper.Viper, c *cobra.Command, name string, shorthand string
 	switch defaultVal := defaultVal.(type) {
 	case string:
 		flags.StringP(name, shorthand, defaultVal, usage)
+	case []string:
+		flags.StringArrayP(name, shorthand, defaultVal, usage)
 	}
 
 	err := v.BindPFlag(name, flags.Lookup(name))
@@ -15,15 +15,23 @@ import (
 // todo 优化：使用占位符逻辑，当 key 生成就放入，只有放入了 key 才能写消息，优化任意 topic 的消息都能写入造成内存泄漏。
 type WsHub struct {
 	conns   map[string]*websocket.Conn
+	subs    map[string]map[chan Event]struct{}
 	history *ttlpool.Pool[[]Message]
 	l       sync.Mutex
 }
 
 type Message []byte
 
+// Event 是带序号的消息，Id 从 1 开始，用于 SSE 的断点续传
+type Event struct {
+	Id   int
+	Body Message
+}
+
 func NewHub() *WsHub {
 	return &WsHub{
 		conns:   map[string]*websocket.Conn{},
+		subs:    map[string]map[chan Event]struct{}{},
 		l:       sync.Mutex{},
 		history: ttlpool.NewPool[[]Message](),
 	}
@@ -63,15 +71,83 @@ var EOF = []byte("EOF")
 
 var historyTtl = time.Minute * 5
 
+// subBuffer 是订阅者的缓冲大小，订阅者处理不及时时会被断开，需要使用 Last-Event-ID 重新订阅
+var subBuffer = 256
+
+// Subscribe 订阅 key 的消息，不同于 Add，它可以被多个订阅者同时订阅。
+// 返回 lastId 之后的历史消息，之后的消息从 ch 中读取；
+// 历史中已经有 EOF 时 ch 为已关闭的 channel，否则收到 EOF 或者订阅者被断开时 ch 会被关闭。
+func (h *WsHub) Subscribe(key string, lastId int) (history []Event, ch <-chan Event, cancel func()) {
+	h.l.Lock()
+	defer h.l.Unlock()
+
+	messages, _ := h.history.Get(key)
+	for i, v := range messages {
+		if i+1 > lastId {
+			history = append(history, Event{Id: i + 1, Body: v})
+		}
+		if bytes.Equal(v, EOF) {
+			c := make(chan Event)
+			close(c)
+			return history, c, func() {}
+		}
+	}
+
+	c := make(chan Event, subBuffer)
+	if h.subs[key] == nil {
+		h.subs[key] = map[chan Event]struct{}{}
+	}
+	h.subs[key][c] = struct{}{}
+
+	return history, c, func() {
+		h.l.Lock()
+		defer h.l.Unlock()
+		h.unsubscribe(key, c)
+	}
+}
+
+// HasHistory 返回 key 是否有还没有过期的历史消息
+func (h *WsHub) HasHistory(key string) bool {
+	h.l.Lock()
+	defer h.l.Unlock()
+
+	_, ok := h.history.Get(key)
+	return ok
+}
+
+func (h *WsHub) unsubscribe(key string, c chan Event) {
+	if _, ok := h.subs[key][c]; !ok {
+		return
+	}
+	close(c)
+	delete(h.subs[key], c)
+	if len(h.subs[key]) == 0 {
+		delete(h.subs, key)
+	}
+}
+
 func (h *WsHub) Send(key string, body []byte) error {
 	h.l.Lock()
 	defer h.l.Unlock()
+	id := 0
 	h.history.Update(key, func(v []Message) ([]Message, time.Duration) {
+		id = len(v) + 1
 		return append(v, body), historyTtl
 	})
 
+	for c := range h.subs[key] {
+		select {
+		case c <- Event{Id: id, Body: body}:
+		default:
+			h.unsubscribe(key, c)
+		}
+	}
+
 	// close conn
 	if bytes.Equal(body, EOF) {
+		for c := range h.subs[key] {
+			h.unsubscribe(key, c)
+		}
 		if o, ok := h.conns[key]; ok {
 			o.Close()
 		}
@@ -0,0 +1,56 @@
+package ws
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSubscribe(t *testing.T) {
+	h := NewHub()
+	assert.False(t, h.HasHistory("run"))
+	_ = h.Send("run", []byte("a"))
+	_ = h.Send("run", []byte("b"))
+
+	assert.True(t, h.HasHistory("run"))
+	history, ch, cancel := h.Subscribe("run", 0)
+	defer cancel()
+	assert.Equal(t, []Event{{Id: 1, Body: Message("a")}, {Id: 2, Body: Message("b")}}, history)
+
+	// Last-Event-ID 之后的历史
+	history2, _, cancel2 := h.Subscribe("run", 1)
+	cancel2()
+	assert.Equal(t, []Event{{Id: 2, Body: Message("b")}}, history2)
+
+	_ = h.Send("run", []byte("c"))
+	_ = h.Send("run", EOF)
+
+	var got []Event
+	for e := range ch {
+		got = append(got, e)
+	}
+	assert.Equal(t, []Event{{Id: 3, Body: Message("c")}, {Id: 4, Body: EOF}}, got)
+
+	// 运行结束后订阅，只返回历史
+	history, ch, cancel = h.Subscribe("run", 3)
+	defer cancel()
+	assert.Equal(t, []Event{{Id: 4, Body: EOF}}, history)
+	_, ok := <-ch
+	assert.False(t, ok)
+}
+
+func TestSubscribeSlow(t *testing.T) {
+	h := NewHub()
+	_, ch, cancel := h.Subscribe("run", 0)
+	defer cancel()
+
+	for i := 0; i < subBuffer+1; i++ {
+		_ = h.Send("run", []byte("x"))
+	}
+
+	// 订阅者处理不及时被断开
+	n := 0
+	for range ch {
+		n++
+	}
+	assert.Equal(t, subBuffer, n)
+}
@@ -18,6 +18,10 @@ type Flow interface {
 	UpdateFlow(ctx context.Context, component *model.Flow) (err error)
 	DeleteFlow(ctx context.Context, id int64) (err error)
 	GetFlowList(ctx context.Context, component GetFlowListParams) (fs []model.Flow, total int, err error)
+
+	// CreateFlow 与 UpdateFlow 会追加一个新的版本，DeleteFlow 会删除所有版本
+	GetFlowVersions(ctx context.Context, flowId int64, params GetFlowListParams) (vs []model.FlowVersion, total int, err error)
+	GetFlowVersion(ctx context.Context, flowId int64, versionId int64) (v *model.FlowVersion, exist bool, err error)
 }
 
 type GetFlowListParams struct {
@@ -32,6 +32,11 @@ func (b *BoltDBFlow) DeleteFlow(ctx context.Context, id int64) (err error) {
 		return fmt.Errorf("store.Delete error: %w", err)
 	}
 
+	err = b.store.DeleteTree(flowVersionPrefix(id))
+	if err != nil && err != store.ErrKeyNotFound {
+		return fmt.Errorf("store.DeleteTree error: %w", err)
+	}
+
 	return nil
 }
 
@@ -114,29 +119,38 @@ func NewBoltDBFlow(store store.Store) *BoltDBFlow {
 var _ Flow = (*BoltDBFlow)(nil)
 
 func (b *BoltDBFlow) IdSeq(namespace string) (id int64, err error) {
-	// todo add lock
-	kv, err := b.store.Get("id_seq/" + namespace)
-	if err != nil {
-		if err == store.ErrKeyNotFound {
-			err = nil
-		} else {
+	return idSeq(b.store, namespace)
+}
+
+func idSeq(s store.Store, namespace string) (id int64, err error) {
+	key := "id_seq/" + namespace
+	// Get 与 Put 之间可能有其他的写入（如并发的运行），使用 AtomicPut 在冲突时重试
+	for {
+		kv, err := s.Get(key)
+		if err != nil && err != store.ErrKeyNotFound {
 			return 0, fmt.Errorf("get id_seq error: %w", err)
 		}
-	}
-	if kv != nil {
-		err = json.Unmarshal(kv.Value, &id)
+		id = 0
+		if err == nil {
+			err = json.Unmarshal(kv.Value, &id)
+			if err != nil {
+				return 0, err
+			}
+		} else {
+			kv = nil
+		}
+
+		id = id + 1
+		_, _, err = s.AtomicPut(key, []byte(fmt.Sprintf("%v", id)), kv, nil)
+		if err == store.ErrKeyModified || err == store.ErrKeyExists {
+			continue
+		}
 		if err != nil {
 			return 0, err
 		}
-	}
 
-	id = id + 1
-	err = b.store.Put("id_seq/"+namespace, []byte(fmt.Sprintf("%v", id)), nil)
-	if err != nil {
-		return 0, err
+		return id, nil
 	}
-
-	return id, nil
 }
 
 func (b *BoltDBFlow) GetFlowById(ctx context.Context, id int64) (flow *model.Flow, exist bool, err error) {
@@ -218,11 +232,7 @@ func (b *BoltDBFlow) CreateFlow(ctx context.Context, fl *model.Flow) (id int64,
 	now := time.Now()
 	fl.UpdatedAt = now
 	fl.CreatedAt = now
-	bs, err := json.Marshal(fl)
-	if err != nil {
-		return 0, err
-	}
-	err = b.store.Put(fmt.Sprintf("flow/%v", id), bs, nil)
+	err = b.saveFlow(fl)
 	if err != nil {
 		return 0, err
 	}
@@ -244,7 +254,43 @@ func (b *BoltDBFlow) UpdateFlow(ctx context.Context, fl *model.Flow) (err error)
 
 	fl.CreatedAt = existFlow.CreatedAt
 
-	bs, err := json.Marshal(fl)
+	return b.saveFlow(fl)
+}
+
+// flowVersionPrefix 是流程所有版本的 key 前缀。
+// 注意不能以 flow 开头，否则会被 GetFlowList 列出
+func flowVersionPrefix(flowId int64) string {
+	return fmt.Sprintf("version/flow/%d/", flowId)
+}
+
+// flowVersionKey 中的版本号补齐为固定长度，这样 key 的顺序就是版本的顺序
+func flowVersionKey(flowId int64, versionId int64) string {
+	return fmt.Sprintf("%s%010d", flowVersionPrefix(flowId), versionId)
+}
+
+// saveFlow 追加一个新的版本，然后保存流程
+func (b *BoltDBFlow) saveFlow(fl *model.Flow) (err error) {
+	fl.Version, err = b.IdSeq(fmt.Sprintf("version/flow/%d", fl.Id))
+	if err != nil {
+		return err
+	}
+
+	v := model.FlowVersion{
+		Id:        fl.Version,
+		FlowId:    fl.Id,
+		Flow:      *fl,
+		CreatedAt: time.Now(),
+	}
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	err = b.store.Put(flowVersionKey(fl.Id, v.Id), bs, nil)
+	if err != nil {
+		return err
+	}
+
+	bs, err = json.Marshal(fl)
 	if err != nil {
 		return err
 	}
@@ -253,5 +299,59 @@ func (b *BoltDBFlow) UpdateFlow(ctx context.Context, fl *model.Flow) (err error)
 		return err
 	}
 
-	return
+	return nil
+}
+
+// GetFlowVersions 返回流程的版本，新的在前，不包含 graph
+func (b *BoltDBFlow) GetFlowVersions(ctx context.Context, flowId int64, params GetFlowListParams) (vs []model.FlowVersion, total int, err error) {
+	kv, err := b.store.List(flowVersionPrefix(flowId))
+	if err != nil {
+		if err == store.ErrKeyNotFound {
+			return nil, 0, nil
+		}
+		return nil, 0, err
+	}
+
+	sort.Slice(kv, func(i, j int) bool {
+		return kv[i].Key > kv[j].Key
+	})
+
+	for i, item := range kv {
+		if i < params.Offset {
+			continue
+		}
+		if params.Limit > 0 && len(vs) >= params.Limit {
+			break
+		}
+		v := model.FlowVersion{}
+		err = json.Unmarshal(item.Value, &v)
+		if err != nil {
+			err = fmt.Errorf("json.Unmarshal error: %w", err)
+			return nil, 0, err
+		}
+		v.Flow.Graph.Nodes = nil
+
+		vs = append(vs, v)
+	}
+
+	return vs, len(kv), nil
+}
+
+func (b *BoltDBFlow) GetFlowVersion(ctx context.Context, flowId int64, versionId int64) (v *model.FlowVersion, exist bool, err error) {
+	kv, err := b.store.Get(flowVersionKey(flowId, versionId))
+	if err != nil {
+		if err == store.ErrKeyNotFound {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	v = &model.FlowVersion{}
+	err = json.Unmarshal(kv.Value, v)
+	if err != nil {
+		err = fmt.Errorf("json.Unmarshal error: %w", err)
+		return nil, false, err
+	}
+
+	return v, true, nil
 }
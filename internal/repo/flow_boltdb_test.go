@@ -5,6 +5,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/zbysir/writeflow/internal/model"
 	"github.com/zbysir/writeflow/pkg/writeflow"
+	"sync"
 	"testing"
 )
 
@@ -108,3 +109,96 @@ func TestFlow(t *testing.T) {
 
 	assert.Equal(t, int(0), total)
 }
+
+func TestFlowVersion(t *testing.T) {
+	x, err := NewKvDb(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := x.Open("db", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := NewBoltDBFlow(s)
+	ctx := context.Background()
+
+	fl := &model.Flow{Name: "v1"}
+	id, err := f.CreateFlow(ctx, fl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(1), fl.Version)
+
+	for _, name := range []string{"v2", "v3"} {
+		err = f.UpdateFlow(ctx, &model.Flow{Id: id, Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 版本不能出现在流程列表中
+	_, total, err := f.GetFlowList(ctx, GetFlowListParams{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, total)
+
+	vs, total, err := f.GetFlowVersions(ctx, id, GetFlowListParams{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 3, total)
+	assert.Equal(t, []int64{3, 2}, []int64{vs[0].Id, vs[1].Id})
+	assert.Equal(t, "v3", vs[0].Flow.Name)
+
+	v, exist, err := f.GetFlowVersion(ctx, id, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, exist)
+	assert.Equal(t, "v1", v.Flow.Name)
+
+	err = f.DeleteFlow(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, total, err = f.GetFlowVersions(ctx, id, GetFlowListParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, total)
+}
+
+func TestIdSeq(t *testing.T) {
+	x, err := NewKvDb(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := x.Open("db", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 并发获取的 id 不能重复
+	var wg sync.WaitGroup
+	ids := make([]int64, 50)
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id, err := idSeq(s, "test")
+			assert.NoError(t, err)
+			ids[i] = id
+		}(i)
+	}
+	wg.Wait()
+
+	seen := map[int64]bool{}
+	for _, id := range ids {
+		assert.False(t, seen[id], "duplicate id %d", id)
+		seen[id] = true
+	}
+	id, err := idSeq(s, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(ids)+1), id)
+}
@@ -3,6 +3,7 @@ package repo
 import (
 	"context"
 	"github.com/zbysir/writeflow/internal/model"
+	"github.com/zbysir/writeflow/pkg/writeflow"
 )
 
 type RunLog interface {
@@ -10,11 +11,13 @@ type RunLog interface {
 	CreateRunLog(ctx context.Context, component *model.RunLog) (err error)
 	UpdateRunLog(ctx context.Context, component *model.RunLog) (err error)
 	DeleteRunLog(ctx context.Context, id int64) (err error)
+	// GetRunLogList 返回的记录不包含节点结果，需要使用 GetRunLogById 获取
 	GetRunLogList(ctx context.Context, component GetRunLogListParams) (fs []model.RunLog, total int, err error)
 }
 
 type GetRunLogListParams struct {
-	FlowId int `json:"flow_id" form:"flow_id"`
-	Limit  int `json:"limit" form:"limit"`
-	Offset int `json:"offset" form:"offset"`
+	FlowId int                  `json:"flow_id" form:"flow_id"`
+	Status writeflow.NodeStatus `json:"status" form:"status"`
+	Limit  int                  `json:"limit" form:"limit"`
+	Offset int                  `json:"offset" form:"offset"`
 }
@@ -0,0 +1,146 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/docker/libkv/store"
+	"github.com/zbysir/writeflow/internal/model"
+	"sort"
+)
+
+// BoltDBRunLog 基于 boltdb 保存运行记录，与 BoltDBFlow 一样不适合太大的数据量。
+// 完整的记录保存在 run_log/{id}，列表只读取 run_log_summary/{id} 中不包含节点结果的摘要。
+type BoltDBRunLog struct {
+	store store.Store
+}
+
+func NewBoltDBRunLog(store store.Store) *BoltDBRunLog {
+	return &BoltDBRunLog{store: store}
+}
+
+var _ RunLog = (*BoltDBRunLog)(nil)
+
+func (b *BoltDBRunLog) GetRunLogById(ctx context.Context, id int64) (l *model.RunLog, exist bool, err error) {
+	kv, err := b.store.Get(fmt.Sprintf("run_log/%v", id))
+	if err != nil {
+		if err == store.ErrKeyNotFound {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	if kv == nil {
+		return
+	}
+
+	l = &model.RunLog{}
+	err = json.Unmarshal(kv.Value, l)
+	if err != nil {
+		err = fmt.Errorf("json.Unmarshal error: %w", err)
+		return nil, false, err
+	}
+
+	exist = true
+
+	return
+}
+
+func (b *BoltDBRunLog) CreateRunLog(ctx context.Context, l *model.RunLog) (err error) {
+	id, err := idSeq(b.store, "run_log")
+	if err != nil {
+		return err
+	}
+	l.Id = id
+
+	return b.put(l)
+}
+
+func (b *BoltDBRunLog) UpdateRunLog(ctx context.Context, l *model.RunLog) (err error) {
+	if l.Id == 0 {
+		return fmt.Errorf("id is empty")
+	}
+
+	return b.put(l)
+}
+
+func (b *BoltDBRunLog) put(l *model.RunLog) (err error) {
+	bs, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	err = b.store.Put(fmt.Sprintf("run_log/%v", l.Id), bs, nil)
+	if err != nil {
+		return err
+	}
+
+	return b.putSummary(l)
+}
+
+func (b *BoltDBRunLog) putSummary(l *model.RunLog) (err error) {
+	bs, err := json.Marshal(l.Summary())
+	if err != nil {
+		return err
+	}
+
+	return b.store.Put(fmt.Sprintf("run_log_summary/%v", l.Id), bs, nil)
+}
+
+func (b *BoltDBRunLog) DeleteRunLog(ctx context.Context, id int64) (err error) {
+	err = b.store.Delete(fmt.Sprintf("run_log/%d", id))
+	if err != nil {
+		return fmt.Errorf("store.Delete error: %w", err)
+	}
+	err = b.store.Delete(fmt.Sprintf("run_log_summary/%d", id))
+	if err != nil && err != store.ErrKeyNotFound {
+		return fmt.Errorf("store.Delete error: %w", err)
+	}
+
+	return nil
+}
+
+func (b *BoltDBRunLog) GetRunLogList(ctx context.Context, params GetRunLogListParams) (ls []model.RunLog, total int, err error) {
+	kv, err := b.store.List("run_log_summary/")
+	if err != nil {
+		if err == store.ErrKeyNotFound {
+			return nil, 0, nil
+		}
+		return nil, 0, fmt.Errorf("store.List error: %w", err)
+	}
+
+	var all []model.RunLog
+	for _, item := range kv {
+		l := model.RunLog{}
+		err = json.Unmarshal(item.Value, &l)
+		if err != nil {
+			err = fmt.Errorf("json.Unmarshal error: %w", err)
+			return nil, 0, err
+		}
+		if params.FlowId != 0 && l.FlowId != int64(params.FlowId) {
+			continue
+		}
+		if params.Status != "" && l.Status != params.Status {
+			continue
+		}
+
+		all = append(all, l)
+	}
+
+	// 新的在前
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Id > all[j].Id
+	})
+
+	for i, l := range all {
+		if i < params.Offset {
+			continue
+		}
+		if params.Limit > 0 && len(ls) >= params.Limit {
+			break
+		}
+
+		ls = append(ls, l)
+	}
+
+	return ls, len(all), nil
+}
@@ -0,0 +1,83 @@
+package repo
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/zbysir/writeflow/internal/model"
+	"github.com/zbysir/writeflow/pkg/writeflow"
+	"testing"
+	"time"
+)
+
+func TestRunLog(t *testing.T) {
+	x, err := NewKvDb(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := x.Open("db", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewBoltDBRunLog(s)
+	ctx := context.Background()
+
+	for _, flowId := range []int64{1, 1, 2} {
+		l := &model.RunLog{
+			FlowId:   flowId,
+			Status:   writeflow.StatusRunning,
+			CreateAt: time.Now(),
+		}
+		err = r.CreateRunLog(ctx, l)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		l.SetNodeStatus(writeflow.NodeStatusLog{NodeId: "a", Status: writeflow.StatusRunning})
+		l.SetNodeStatus(writeflow.NodeStatusLog{NodeId: "a", Status: writeflow.StatusSuccess})
+		l.Finish(time.Now())
+		err = r.UpdateRunLog(ctx, l)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ls, total, err := r.GetRunLogList(ctx, GetRunLogListParams{FlowId: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, total)
+	assert.Equal(t, int64(2), ls[0].Id)
+	assert.Equal(t, writeflow.StatusSuccess, ls[0].Status)
+	// 列表中只有摘要
+	assert.Nil(t, ls[0].Result)
+
+	l, exist, err := r.GetRunLogById(ctx, ls[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, exist)
+	assert.Equal(t, 1, len(l.Result))
+
+	_, total, err = r.GetRunLogList(ctx, GetRunLogListParams{Status: writeflow.StatusRunning})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, total)
+
+	err = r.DeleteRunLog(ctx, ls[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, exist, err = r.GetRunLogById(ctx, ls[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, false, exist)
+
+	_, total, err = r.GetRunLogList(ctx, GetRunLogListParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, total)
+}
@@ -8,4 +8,10 @@ import (
 type System interface {
 	GetSetting(ctx context.Context) (s *model.Setting, err error)
 	SaveSetting(ctx context.Context, s *model.Setting) (err error)
+
+	// 发布为 HTTP 接口的流程
+	GetApps(ctx context.Context) (as []model.App, err error)
+	GetApp(ctx context.Context, slug string) (a *model.App, exist bool, err error)
+	SaveApp(ctx context.Context, a *model.App) (err error)
+	DeleteApp(ctx context.Context, slug string) (err error)
 }
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/docker/libkv/store"
 	"github.com/zbysir/writeflow/internal/model"
+	"sort"
 )
 
 type BoltDBSystem struct {
@@ -58,4 +59,74 @@ func (b *BoltDBSystem) SaveSetting(ctx context.Context, s *model.Setting) (err e
 	return nil
 }
 
+func appKey(slug string) string {
+	return fmt.Sprintf("system/app/%s", slug)
+}
+
+func (b *BoltDBSystem) GetApps(ctx context.Context) (as []model.App, err error) {
+	kv, err := b.store.List("system/app/")
+	if err != nil {
+		if err == store.ErrKeyNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("store.List error: %w", err)
+	}
+
+	for _, item := range kv {
+		a := model.App{}
+		err = json.Unmarshal(item.Value, &a)
+		if err != nil {
+			err = fmt.Errorf("json.Unmarshal error: %w", err)
+			return nil, err
+		}
+		as = append(as, a)
+	}
+
+	sort.Slice(as, func(i, j int) bool {
+		return as[i].Slug < as[j].Slug
+	})
+	return as, nil
+}
+
+func (b *BoltDBSystem) GetApp(ctx context.Context, slug string) (a *model.App, exist bool, err error) {
+	kv, err := b.store.Get(appKey(slug))
+	if err != nil {
+		if err == store.ErrKeyNotFound {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("store.Get error: %w", err)
+	}
+
+	a = &model.App{}
+	err = json.Unmarshal(kv.Value, a)
+	if err != nil {
+		err = fmt.Errorf("json.Unmarshal error: %w", err)
+		return nil, false, err
+	}
+
+	return a, true, nil
+}
+
+func (b *BoltDBSystem) SaveApp(ctx context.Context, a *model.App) (err error) {
+	bs, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	err = b.store.Put(appKey(a.Slug), bs, nil)
+	if err != nil {
+		return fmt.Errorf("store.Put error: %w", err)
+	}
+
+	return nil
+}
+
+func (b *BoltDBSystem) DeleteApp(ctx context.Context, slug string) (err error) {
+	err = b.store.Delete(appKey(slug))
+	if err != nil && err != store.ErrKeyNotFound {
+		return fmt.Errorf("store.Delete error: %w", err)
+	}
+
+	return nil
+}
+
 var _ System = (*BoltDBSystem)(nil)
@@ -0,0 +1,158 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"github.com/zbysir/writeflow/internal/model"
+	"github.com/zbysir/writeflow/pkg/writeflow"
+	"sync"
+	"time"
+)
+
+// PublishApp 创建或更新 App，更新时保留已有的 key
+func (u *Flow) PublishApp(ctx context.Context, app *model.App) (*model.App, error) {
+	err := app.Check()
+	if err != nil {
+		return nil, err
+	}
+	_, err = u.GetFlowVersion(ctx, app.FlowId, app.VersionId)
+	if err != nil {
+		return nil, err
+	}
+
+	old, exist, err := u.sysRepo.GetApp(ctx, app.Slug)
+	if err != nil {
+		return nil, err
+	}
+	app.Keys = []model.AppKey{}
+	app.CreatedAt = time.Now()
+	if exist {
+		app.Keys = old.Keys
+		app.CreatedAt = old.CreatedAt
+	}
+	if app.Params == nil {
+		app.Params = []model.AppParam{}
+	}
+	app.UpdatedAt = time.Now()
+
+	err = u.sysRepo.SaveApp(ctx, app)
+	if err != nil {
+		return nil, err
+	}
+	return app, nil
+}
+
+func (u *Flow) getApp(ctx context.Context, slug string) (*model.App, error) {
+	app, exist, err := u.sysRepo.GetApp(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, fmt.Errorf("app '%s' not exist", slug)
+	}
+	return app, nil
+}
+
+// CreateAppKey 为 App 创建新的 API key，返回的明文 key 不会被保存
+func (u *Flow) CreateAppKey(ctx context.Context, slug string, name string) (key string, k model.AppKey, err error) {
+	app, err := u.getApp(ctx, slug)
+	if err != nil {
+		return "", k, err
+	}
+	key, k, err = model.NewAppKey(name)
+	if err != nil {
+		return "", k, err
+	}
+
+	app.Keys = append(app.Keys, k)
+	err = u.sysRepo.SaveApp(ctx, app)
+	if err != nil {
+		return "", k, err
+	}
+
+	k.Hash = ""
+	return key, k, nil
+}
+
+func (u *Flow) DeleteAppKey(ctx context.Context, slug string, keyId string) error {
+	app, err := u.getApp(ctx, slug)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]model.AppKey, 0, len(app.Keys))
+	for _, k := range app.Keys {
+		if k.Id != keyId {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == len(app.Keys) {
+		return fmt.Errorf("key '%s' not exist", keyId)
+	}
+	app.Keys = keys
+
+	return u.sysRepo.SaveApp(ctx, app)
+}
+
+// RunApp 运行 App 对应的流程，返回输出节点的结果。
+// 输出节点中有流时，每读取到新的内容都会调用 onStream，delta 为这次新增的内容。
+func (u *Flow) RunApp(ctx context.Context, app *model.App, params map[string]interface{}, onStream func(key string, delta string)) (rsp interface{}, err error) {
+	params, err = app.CheckParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	flow, err := u.GetFlowVersion(ctx, app.FlowId, app.VersionId)
+	if err != nil {
+		return nil, err
+	}
+
+	if onStream != nil {
+		outputNodeId := flow.Graph.OutputNodeId
+		var lock sync.Mutex
+		sent := map[string]int{}
+		ctx = writeflow.WithStatusReporter(ctx, func(s writeflow.NodeStatusLog) {
+			if s.NodeId != outputNodeId || s.Status != writeflow.StatusRunning || len(s.Streaming) == 0 {
+				return
+			}
+
+			// 多个流是并发读取的，需要保证同一个 key 的内容按顺序发送
+			lock.Lock()
+			defer lock.Unlock()
+			for _, k := range s.Streaming {
+				if app.Output != "" && k != app.Output {
+					continue
+				}
+				content, ok := s.ResultRaw[k].(string)
+				if !ok || len(content) <= sent[k] {
+					continue
+				}
+				delta := content[sent[k]:]
+				sent[k] = len(content)
+				onStream(k, delta)
+			}
+		})
+	}
+
+	r, err := u.RunFlowByDetailSync(ctx, flow, params, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return AppOutput(app, r), nil
+}
+
+// AppOutput 将输出节点的结果转为接口的返回值，会过滤私密信息
+func AppOutput(app *model.App, r writeflow.Map) interface{} {
+	rr := writeflow.Map{}
+	for k, v := range r {
+		if d, ok := v.(interface{ Display() string }); ok {
+			v = d.Display()
+		}
+		rr[k] = v
+	}
+	if app.Output != "" {
+		return rr[app.Output]
+	}
+	return rr
+}
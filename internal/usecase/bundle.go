@@ -0,0 +1,87 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"github.com/samber/lo"
+	"github.com/zbysir/writeflow/internal/model"
+	"github.com/zbysir/writeflow/pkg/writeflow"
+	"sort"
+)
+
+// ExportFlow 将流程导出为 bundle，bundle 中包含流程用到的插件
+func (u *Flow) ExportFlow(ctx context.Context, flowId int64) (*model.FlowBundle, error) {
+	flow, exist, err := u.flowRepo.GetFlowById(ctx, flowId)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, fmt.Errorf("flow not exist")
+	}
+
+	var plugins []string
+	pluginCmds := u.getPluginCmds()
+	for _, cmd := range flow.UsedComponents() {
+		if url, ok := pluginCmds[cmd]; ok {
+			plugins = append(plugins, url)
+		}
+	}
+	plugins = lo.Uniq(plugins)
+	sort.Strings(plugins)
+
+	return model.NewFlowBundle(flow, plugins), nil
+}
+
+// MissingComponent 是导入的流程中使用了但是当前实例中不存在的组件
+type MissingComponent struct {
+	NodeId    string `json:"node_id"`
+	Component string `json:"component"` // 组件类型，即 Node.Type
+	Cmd       string `json:"cmd"`
+}
+
+type ImportFlowResult struct {
+	Id                int64              `json:"id"`
+	MissingComponents []MissingComponent `json:"missing_components"`
+	MissingPlugins    []string           `json:"missing_plugins"` // bundle 需要但是没有启用的插件，安装后缺少的组件才可用
+}
+
+// ImportFlow 将 bundle 保存为新的流程。
+// 缺少组件时仍然会导入（安装插件之后就可以运行），缺少的组件与插件在结果中返回。
+func (u *Flow) ImportFlow(ctx context.Context, b *model.FlowBundle) (*ImportFlowResult, error) {
+	r := &ImportFlowResult{
+		MissingComponents: []MissingComponent{},
+		MissingPlugins:    []string{},
+	}
+
+	for _, node := range b.Graph.Nodes {
+		switch node.Data.Source.CmdType {
+		case writeflow.GoScriptCmd, writeflow.JavaScriptCmd:
+			continue
+		}
+		cmd := model.NodeCmd(node)
+		if !u.getWriteFlow().HasCmd(cmd) {
+			r.MissingComponents = append(r.MissingComponents, MissingComponent{
+				NodeId:    node.Id,
+				Component: node.Type,
+				Cmd:       cmd,
+			})
+		}
+	}
+
+	setting, err := u.sysRepo.GetSetting(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, url := range b.Plugins {
+		if !lo.ContainsBy(setting.Plugins, func(p model.PluginSource) bool { return p.Url == url && p.Enable }) {
+			r.MissingPlugins = append(r.MissingPlugins, url)
+		}
+	}
+
+	r.Id, err = u.flowRepo.CreateFlow(ctx, b.Flow())
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
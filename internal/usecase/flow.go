@@ -2,6 +2,7 @@ package usecase
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
@@ -9,20 +10,28 @@ import (
 	"github.com/zbysir/writeflow/internal/pkg/log"
 	"github.com/zbysir/writeflow/internal/pkg/ws"
 	"github.com/zbysir/writeflow/internal/repo"
+	"github.com/zbysir/writeflow/pkg/export"
 	"github.com/zbysir/writeflow/pkg/modules/builtin"
 	"github.com/zbysir/writeflow/pkg/modules/llm"
 	"github.com/zbysir/writeflow/pkg/writeflow"
+	"sync"
 	"time"
 )
 
 type Flow struct {
-	flowRepo repo.Flow
-	sysRepo  repo.System
+	flowRepo   repo.Flow
+	sysRepo    repo.System
+	runLogRepo repo.RunLog
 	//documentRepo repo.Document
 	vectorStoreFactory llm.VectorStoreFactory
 	wirteflow          *writeflow.WriteFlow
 	ws                 *ws.WsHub
 	PluginStatus       []PluginStatus
+	pluginCmds         map[string]string // cmd -> 注册它的插件地址
+	wfLock             sync.RWMutex      // 保护 wirteflow 和 pluginCmds，ReloadWriteFlow 时会替换它们
+
+	runCancels map[string]context.CancelFunc // runId -> cancel
+	runLock    sync.Mutex
 }
 type PluginStatus struct {
 	model.PluginSource
@@ -30,14 +39,16 @@ type PluginStatus struct {
 	Error  string `json:"error"`
 }
 
-func NewFlow(flowRepo repo.Flow, sysRepo repo.System, vectorStoreFactory llm.VectorStoreFactory) (*Flow, error) {
+func NewFlow(flowRepo repo.Flow, sysRepo repo.System, runLogRepo repo.RunLog, vectorStoreFactory llm.VectorStoreFactory) (*Flow, error) {
 	f := &Flow{
 		flowRepo:           flowRepo,
 		sysRepo:            sysRepo,
+		runLogRepo:         runLogRepo,
 		vectorStoreFactory: vectorStoreFactory,
 		wirteflow:          nil,
 		ws:                 ws.NewHub(),
 		PluginStatus:       nil,
+		runCancels:         map[string]context.CancelFunc{},
 	}
 
 	err := f.ReloadWriteFlow(context.Background())
@@ -54,6 +65,7 @@ func (u *Flow) ReloadWriteFlow(ctx context.Context) error {
 
 	wf.RegisterModule(builtin.New())
 	wf.RegisterPlugin(llm.NewLangChain(u.vectorStoreFactory))
+	wf.RegisterModule(&subFlowModule{u: u})
 
 	setting, err := u.sysRepo.GetSetting(ctx)
 	if err != nil {
@@ -62,6 +74,7 @@ func (u *Flow) ReloadWriteFlow(ctx context.Context) error {
 
 	// 加载插件
 	u.PluginStatus = []PluginStatus{}
+	pluginCmds := map[string]string{}
 	pm := writeflow.NewGoPkgPluginManager(nil)
 
 	for _, p := range setting.Plugins {
@@ -80,7 +93,7 @@ func (u *Flow) ReloadWriteFlow(ctx context.Context) error {
 			log.Errorf("register plugin '%+v' error: %v", p.Url, err)
 			continue
 		} else {
-			err = plug.Register(wf)
+			err = plug.Register(&pluginRegister{wf: wf, url: p.Url, cmds: pluginCmds})
 			if err != nil {
 				u.PluginStatus = append(u.PluginStatus, PluginStatus{
 					PluginSource: p,
@@ -101,18 +114,49 @@ func (u *Flow) ReloadWriteFlow(ctx context.Context) error {
 		log.Infof("register plugin '%+v' success", p.Url)
 	}
 
+	u.wfLock.Lock()
 	u.wirteflow = wf
+	u.pluginCmds = pluginCmds
+	u.wfLock.Unlock()
 
 	return nil
 }
 
+// getWriteFlow 返回当前的 WriteFlow，运行中的流程继续使用取到的实例，不受重新加载影响
+func (u *Flow) getWriteFlow() *writeflow.WriteFlow {
+	u.wfLock.RLock()
+	defer u.wfLock.RUnlock()
+	return u.wirteflow
+}
+
+// getPluginCmds 返回当前注册了 cmd 的插件
+func (u *Flow) getPluginCmds() map[string]string {
+	u.wfLock.RLock()
+	defer u.wfLock.RUnlock()
+	return u.pluginCmds
+}
+
+// pluginRegister 记录插件注册了哪些 cmd，用于导出流程时得到流程需要的插件
+type pluginRegister struct {
+	wf   *writeflow.WriteFlow
+	url  string
+	cmds map[string]string
+}
+
+func (r *pluginRegister) RegisterPlugin(p export.Plugin) {
+	for k := range p.Cmd() {
+		r.cmds[k] = r.url
+	}
+	r.wf.RegisterPlugin(p)
+}
+
 func (u *Flow) GetComponents(ctx context.Context) (cs []writeflow.CategoryWithComponent, err error) {
-	cs = u.wirteflow.GetComponentList()
+	cs = u.getWriteFlow().GetComponentList()
 	return cs, nil
 }
 
 func (u *Flow) GetComponentByKey(ctx context.Context, key string) (cs writeflow.Component, exist bool, err error) {
-	cs, exist, err = u.wirteflow.GetComponentByKey(key)
+	cs, exist, err = u.getWriteFlow().GetComponentByKey(key)
 	return
 }
 
@@ -121,35 +165,34 @@ type RunStatusMessage struct {
 	Data []byte
 }
 
-func (u *Flow) RunFlow(ctx context.Context, flowId int64, params map[string]interface{}, parallel int) (runId string, err error) {
-	flow, exist, err := u.flowRepo.GetFlowById(ctx, flowId)
+// RunFlow 运行流程，versionId 不为 0 时运行指定的版本，否则运行最新保存的流程
+func (u *Flow) RunFlow(ctx context.Context, flowId int64, versionId int64, params map[string]interface{}, parallel int, ops ...writeflow.ExecOption) (runId string, err error) {
+	flow, err := u.GetFlowVersion(ctx, flowId, versionId)
 	if err != nil {
 		return "", err
 	}
-	if !exist {
-		return "", fmt.Errorf("flow not exist")
-	}
 
-	return u.RunFlowByDetail(ctx, flow, params, parallel)
+	return u.RunFlowByDetail(ctx, flow, params, parallel, ops...)
 }
 
-func (u *Flow) RunFlowSync(ctx context.Context, flowId int64, params map[string]interface{}, parallel int, outputNodeId string) (rsp writeflow.Map, err error) {
-	flow, exist, err := u.flowRepo.GetFlowById(ctx, flowId)
+func (u *Flow) RunFlowSync(ctx context.Context, flowId int64, versionId int64, params map[string]interface{}, parallel int, outputNodeId string, ops ...writeflow.ExecOption) (rsp writeflow.Map, err error) {
+	flow, err := u.GetFlowVersion(ctx, flowId, versionId)
 	if err != nil {
 		return writeflow.Map{}, err
 	}
-	if !exist {
-		return writeflow.Map{}, fmt.Errorf("flow not exist")
-	}
 
 	if outputNodeId != "" {
 		flow.Graph.OutputNodeId = outputNodeId
 	}
 
-	return u.RunFlowByDetailSync(ctx, flow, params, parallel)
+	return u.RunFlowByDetailSync(ctx, flow, params, parallel, ops...)
 }
 
-func (u *Flow) RunFlowByDetail(ctx context.Context, flow *model.Flow, params map[string]interface{}, parallel int) (runId string, err error) {
+func (u *Flow) RunFlowByDetail(ctx context.Context, flow *model.Flow, params map[string]interface{}, parallel int, ops ...writeflow.ExecOption) (runId string, err error) {
+	flow, err = u.resolveSubFlows(ctx, flow)
+	if err != nil {
+		return "", err
+	}
 	f, err := model.FlowFromModel(flow)
 	if err != nil {
 		return "", err
@@ -160,16 +203,22 @@ func (u *Flow) RunFlowByDetail(ctx context.Context, flow *model.Flow, params map
 
 	runId = fmt.Sprintf("flow.%s", uuid.New().String())
 
-	status, err := u.wirteflow.ExecFlowAsync(ctx, f, params, parallel)
+	ctx, cancel := context.WithCancel(withSubFlow(ctx, flow.Id, parallel))
+	status, err := u.getWriteFlow().ExecFlowAsync(ctx, f, params, parallel, ops...)
 	if err != nil {
+		cancel()
 		return "", err
 	}
+	u.addRunCancel(runId, cancel)
 
 	// get status async
 	log.Infof("%s start", runId)
 	start := time.Now()
+	runLog := u.createRunLog(ctx, flow.Id, f.OutputNodeId, runId, start)
 	go func() {
 		defer func() {
+			u.removeRunCancel(runId)
+			runLog.Finish(nil)
 			log.Infof("%s end, spend: %s", runId, time.Now().Sub(start))
 		}()
 
@@ -179,6 +228,7 @@ func (u *Flow) RunFlowByDetail(ctx context.Context, flow *model.Flow, params map
 				log.Errorf("status to json err: %v", err)
 				return
 			}
+			runLog.SetNodeStatus(r)
 			//log.Infof("%s %s", runId, bs)
 			err = u.ws.Send(runId, bs)
 			if err != nil {
@@ -196,15 +246,188 @@ func (u *Flow) RunFlowByDetail(ctx context.Context, flow *model.Flow, params map
 	return
 }
 
-func (u *Flow) RunFlowByDetailSync(ctx context.Context, flow *model.Flow, params map[string]interface{}, parallel int) (rsp writeflow.Map, err error) {
+func (u *Flow) RunFlowByDetailSync(ctx context.Context, flow *model.Flow, params map[string]interface{}, parallel int, ops ...writeflow.ExecOption) (rsp writeflow.Map, err error) {
+	flow, err = u.resolveSubFlows(ctx, flow)
+	if err != nil {
+		return writeflow.Map{}, err
+	}
 	f, err := model.FlowFromModel(flow)
 	if err != nil {
 		return writeflow.Map{}, err
 	}
 
-	return u.wirteflow.ExecNode(ctx, f, params, parallel)
+	start := time.Now()
+	runLog := u.createRunLog(ctx, flow.Id, f.OutputNodeId, fmt.Sprintf("flow.%s", uuid.New().String()), start)
+	defer func() {
+		runLog.Finish(err)
+	}()
+
+	// 同时保留调用方设置的 reporter，如 RunApp 读取输出节点的流
+	report := writeflow.GetStatusReporter(ctx)
+	ctx = writeflow.WithStatusReporter(ctx, func(s writeflow.NodeStatusLog) {
+		runLog.SetNodeStatus(s)
+		if report != nil {
+			report(s)
+		}
+	})
+
+	return u.getWriteFlow().ExecNode(withSubFlow(ctx, flow.Id, parallel), f, params, parallel, ops...)
+}
+
+// ValidateFlow 在运行前检查流程的问题，如组件不存在、连线错误、环等。
+func (u *Flow) ValidateFlow(ctx context.Context, flow *model.Flow) (problems []writeflow.FlowProblem, err error) {
+	flow, err = u.resolveSubFlows(ctx, flow)
+	if err != nil {
+		return nil, err
+	}
+	// 类型问题由 ValidateFlow 收集，不在构建时返回第一个
+	f, err := model.BuildFlow(flow)
+	if err != nil {
+		if p, ok := writeflow.ProblemFromError(err); ok {
+			return []writeflow.FlowProblem{p}, nil
+		}
+		return nil, err
+	}
+
+	return u.getWriteFlow().ValidateFlow(f), nil
+}
+
+func (u *Flow) addRunCancel(runId string, cancel context.CancelFunc) {
+	u.runLock.Lock()
+	defer u.runLock.Unlock()
+
+	u.runCancels[runId] = cancel
+}
+
+func (u *Flow) removeRunCancel(runId string) {
+	u.runLock.Lock()
+	defer u.runLock.Unlock()
+
+	if cancel, ok := u.runCancels[runId]; ok {
+		cancel()
+		delete(u.runCancels, runId)
+	}
+}
+
+// CancelRun 取消正在运行的流程，已经在执行中的节点需要自己处理 ctx.Done()，未执行的节点不会再执行。
+func (u *Flow) CancelRun(ctx context.Context, runId string) error {
+	u.runLock.Lock()
+	cancel, ok := u.runCancels[runId]
+	u.runLock.Unlock()
+	if !ok {
+		return fmt.Errorf("run '%s' not found or already finished", runId)
+	}
+
+	cancel()
+	return nil
+}
+
+// runLogSaveInterval 运行中保存运行记录的间隔，进程退出时最多丢失这段时间内的节点状态
+const runLogSaveInterval = time.Second
+
+// runLogger 收集节点的状态写入运行记录，节点的状态可能会并发的上报
+type runLogger struct {
+	u       *Flow
+	l       sync.Mutex
+	log     *model.RunLog
+	savedAt time.Time
+}
+
+// createRunLog 在运行开始时保存状态为 running 的运行记录
+func (u *Flow) createRunLog(ctx context.Context, flowId int64, outputNodeId string, runId string, start time.Time) *runLogger {
+	l := &model.RunLog{
+		RunId:        runId,
+		FlowId:       flowId,
+		OutputNodeId: outputNodeId,
+		Status:       writeflow.StatusRunning,
+		CreateAt:     start,
+	}
+	err := u.runLogRepo.CreateRunLog(ctx, l)
+	if err != nil {
+		log.Errorf("create run log err: %v", err)
+	}
+
+	return &runLogger{u: u, log: l, savedAt: start}
+}
+
+// SetNodeStatus 记录节点的状态，运行中每隔 runLogSaveInterval 保存一次
+func (r *runLogger) SetNodeStatus(s writeflow.NodeStatusLog) {
+	s.FillResult()
+
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	r.log.SetNodeStatus(s)
+	if time.Since(r.savedAt) >= runLogSaveInterval {
+		r.save()
+	}
+}
+
+// Finish 保存运行结束的状态，err 是流程返回的错误，如参数错误时没有节点报告失败状态
+func (r *runLogger) Finish(err error) {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	r.log.Finish(time.Now())
+	if err != nil && r.log.Status == writeflow.StatusSuccess {
+		r.log.Status = writeflow.StatusFailed
+		if errors.Is(err, context.Canceled) {
+			r.log.Status = writeflow.StatusCancelled
+		}
+	}
+	r.save()
+}
+
+func (r *runLogger) save() {
+	if r.log.Id == 0 {
+		return
+	}
+	r.savedAt = time.Now()
+	err := r.u.runLogRepo.UpdateRunLog(context.Background(), r.log)
+	if err != nil {
+		log.Errorf("update run log err: %v", err)
+	}
+}
+
+// InterruptRunLogs 将上次进程退出时还在运行的记录标记为失败，需要在服务启动、还没有运行任何流程时调用
+func (u *Flow) InterruptRunLogs(ctx context.Context) error {
+	ls, _, err := u.runLogRepo.GetRunLogList(ctx, repo.GetRunLogListParams{Status: writeflow.StatusRunning})
+	if err != nil {
+		return err
+	}
+
+	for _, s := range ls {
+		l, exist, err := u.runLogRepo.GetRunLogById(ctx, s.Id)
+		if err != nil {
+			return err
+		}
+		if !exist {
+			continue
+		}
+		l.Interrupt(time.Now())
+		err = u.runLogRepo.UpdateRunLog(ctx, l)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 func (u *Flow) AddWs(key string, conn *websocket.Conn) {
 	u.ws.Add(key, conn)
 }
+
+// SubscribeRun 订阅运行的状态，先返回 lastEventId 之后的历史状态，最后一条消息为 ws.EOF。
+// 运行不存在，或者已经结束并且历史已经过期时 exist 为 false。
+func (u *Flow) SubscribeRun(runId string, lastEventId int) (history []ws.Event, ch <-chan ws.Event, cancel func(), exist bool) {
+	u.runLock.Lock()
+	_, running := u.runCancels[runId]
+	u.runLock.Unlock()
+	if !running && !u.ws.HasHistory(runId) {
+		return nil, nil, nil, false
+	}
+
+	history, ch, cancel = u.ws.Subscribe(runId, lastEventId)
+	return history, ch, cancel, true
+}
@@ -1,10 +1,130 @@
 package usecase
 
 import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/zbysir/writeflow/internal/model"
+	"github.com/zbysir/writeflow/internal/pkg/ws"
+	"github.com/zbysir/writeflow/internal/repo"
+	"github.com/zbysir/writeflow/pkg/writeflow"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestFlowRun(t *testing.T) {
 	////
 
 }
+
+func textNode(id string, input writeflow.NodeInputParam) model.Node {
+	return model.Node{Id: id, Type: "template_text", Data: writeflow.ComponentData{
+		Source: writeflow.ComponentSource{CmdType: writeflow.BuiltInCmd, BuiltinCmd: "template_text"},
+		InputParams: []writeflow.NodeInputParam{
+			{Key: "mode", InputType: writeflow.NodeInputLiteral, Value: "go"},
+			input,
+		},
+	}}
+}
+
+func newTestFlow(t *testing.T) (*Flow, repo.RunLog) {
+	x, err := repo.NewKvDb(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := x.Open("db", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runLogRepo := repo.NewBoltDBRunLog(s)
+	u, err := NewFlow(repo.NewBoltDBFlow(s), repo.NewBoltDBSystem(s), runLogRepo, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u, runLogRepo
+}
+
+func testTextFlow() *model.Flow {
+	return &model.Flow{Id: 1, Graph: model.Graph{
+		Nodes: model.Nodes{
+			textNode("a", writeflow.NodeInputParam{Key: "template", InputType: writeflow.NodeInputLiteral, Value: "hi"}),
+			textNode("b", writeflow.NodeInputParam{Key: "template", InputType: writeflow.NodeInputAnchor, Anchors: []writeflow.NodeAnchorTarget{{NodeId: "a", OutputKey: "default"}}}),
+		},
+		OutputNodeId: "b",
+	}}
+}
+
+func TestRunLogSync(t *testing.T) {
+	u, runLogRepo := newTestFlow(t)
+	ctx := context.Background()
+
+	// 上次进程退出时没有结束的运行
+	l := &model.RunLog{FlowId: 1, Status: writeflow.StatusRunning, CreateAt: time.Now()}
+	l.SetNodeStatus(writeflow.NodeStatusLog{NodeId: "a", Status: writeflow.StatusRunning})
+	err := runLogRepo.CreateRunLog(ctx, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = u.InterruptRunLogs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, _, err = runLogRepo.GetRunLogById(ctx, l.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, writeflow.StatusFailed, l.Status)
+	assert.Equal(t, "interrupted", l.Result[0].Error)
+
+	// 同步运行时记录所有节点的状态，同时保留调用方的 reporter
+	f := testTextFlow()
+	var reported atomic.Int32
+	rsp, err := u.RunFlowByDetailSync(writeflow.WithStatusReporter(ctx, func(s writeflow.NodeStatusLog) {
+		reported.Add(1)
+	}), f, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "hi", rsp["default"])
+	assert.NotEqual(t, int32(0), reported.Load())
+
+	ls, _, err := runLogRepo.GetRunLogList(ctx, repo.GetRunLogListParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, _, err = runLogRepo.GetRunLogById(ctx, ls[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, writeflow.StatusSuccess, l.Status)
+	assert.Equal(t, 2, len(l.Result))
+	for _, s := range l.Result {
+		assert.Equal(t, writeflow.StatusSuccess, s.Status, s.NodeId)
+		assert.Equal(t, map[string]interface{}{"default": "hi"}, s.Result, s.NodeId)
+	}
+}
+
+func TestSubscribeRun(t *testing.T) {
+	u, _ := newTestFlow(t)
+
+	// 不存在的运行不能订阅，否则会一直等待
+	_, _, _, exist := u.SubscribeRun("flow.unknown", 0)
+	assert.False(t, exist)
+
+	runId, err := u.RunFlowByDetail(context.Background(), testTextFlow(), nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history, ch, cancel, exist := u.SubscribeRun(runId, 0)
+	assert.True(t, exist)
+	defer cancel()
+
+	for e := range ch {
+		history = append(history, e)
+	}
+	assert.Equal(t, ws.Message(ws.EOF), history[len(history)-1].Body)
+
+	// 运行结束后在历史过期之前还可以订阅
+	_, _, _, exist = u.SubscribeRun(runId, 0)
+	assert.True(t, exist)
+}
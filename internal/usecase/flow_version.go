@@ -0,0 +1,63 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"github.com/zbysir/writeflow/internal/model"
+)
+
+// GetFlowVersion 返回流程的指定版本，versionId 为 0 时返回最新保存的流程
+func (u *Flow) GetFlowVersion(ctx context.Context, flowId int64, versionId int64) (*model.Flow, error) {
+	if versionId == 0 {
+		flow, exist, err := u.flowRepo.GetFlowById(ctx, flowId)
+		if err != nil {
+			return nil, err
+		}
+		if !exist {
+			return nil, fmt.Errorf("flow not exist")
+		}
+		return flow, nil
+	}
+
+	v, exist, err := u.flowRepo.GetFlowVersion(ctx, flowId, versionId)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, fmt.Errorf("flow %d version %d not exist", flowId, versionId)
+	}
+	return &v.Flow, nil
+}
+
+// DiffFlowVersion 比较两个版本，版本号为 0 时表示最新保存的流程
+func (u *Flow) DiffFlowVersion(ctx context.Context, flowId int64, from int64, to int64) (*model.FlowDiff, error) {
+	a, err := u.GetFlowVersion(ctx, flowId, from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := u.GetFlowVersion(ctx, flowId, to)
+	if err != nil {
+		return nil, err
+	}
+
+	d := model.DiffFlow(a, b)
+	return &d, nil
+}
+
+// RestoreFlowVersion 将流程恢复为指定版本，恢复会保存为一个新的版本，不会删除之后的版本
+func (u *Flow) RestoreFlowVersion(ctx context.Context, flowId int64, versionId int64) (*model.Flow, error) {
+	v, exist, err := u.flowRepo.GetFlowVersion(ctx, flowId, versionId)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, fmt.Errorf("flow %d version %d not exist", flowId, versionId)
+	}
+
+	flow := v.Flow
+	err = u.flowRepo.UpdateFlow(ctx, &flow)
+	if err != nil {
+		return nil, err
+	}
+	return &flow, nil
+}
@@ -0,0 +1,207 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+	"github.com/zbysir/writeflow/internal/model"
+	"github.com/zbysir/writeflow/pkg/writeflow"
+	"strings"
+)
+
+// subFlowParallel 没有父流程的并发数时（如直接调用 cmd），子流程运行时的并发数
+const subFlowParallel = 4
+
+// subFlowModule 提供 sub_flow 组件，将另一个保存的流程作为节点调用。
+// 节点的输入（除了 flow_id）作为子流程的 _params，子流程输出节点的结果作为节点的输出。
+type subFlowModule struct {
+	u *Flow
+}
+
+var _ writeflow.Module = (*subFlowModule)(nil)
+
+func (m *subFlowModule) Info() writeflow.ModuleInfo {
+	return writeflow.ModuleInfo{
+		NameSpace: "sub_flow",
+	}
+}
+
+func (m *subFlowModule) Categories() []writeflow.Category {
+	// 使用 builtin 的 logic 分类
+	return nil
+}
+
+func (m *subFlowModule) Components() []writeflow.Component {
+	return []writeflow.Component{
+		{
+			Id:       0,
+			Type:     "sub_flow",
+			Category: "logic",
+			Data: writeflow.ComponentData{
+				Name: map[string]string{
+					"zh-CN": "子流程",
+					"en":    "SubFlow",
+				},
+				Source: writeflow.ComponentSource{
+					CmdType:    writeflow.BuiltInCmd,
+					BuiltinCmd: "sub_flow",
+				},
+				// 其他输入作为子流程的 _params
+				DynamicInput: true,
+				InputParams: []writeflow.NodeInputParam{
+					{
+						Name: map[string]string{
+							"zh-CN": "流程 ID",
+						},
+						Key:  "flow_id",
+						Type: "int",
+					},
+				},
+				// 输出为子流程输出节点的结果，运行和检查流程时由 resolveSubFlows 根据子流程得到
+				DynamicOutput: true,
+				OutputAnchors: []writeflow.NodeOutputAnchor{},
+			},
+		},
+	}
+}
+
+func (m *subFlowModule) Cmd() map[string]writeflow.CMDer {
+	return map[string]writeflow.CMDer{
+		"sub_flow": writeflow.NewFun(func(ctx context.Context, params map[string]interface{}) (rsp map[string]interface{}, err error) {
+			flowId := cast.ToInt64(params["flow_id"])
+			if flowId == 0 {
+				return nil, fmt.Errorf("flow_id is required")
+			}
+
+			chain := getSubFlowChain(ctx)
+			if lo.Contains(chain, flowId) {
+				ids := lo.Map(append(chain, flowId), func(item int64, _ int) string { return cast.ToString(item) })
+				return nil, fmt.Errorf("sub flow recursion: %s", strings.Join(ids, " -> "))
+			}
+
+			flow, exist, err := m.u.flowRepo.GetFlowById(ctx, flowId)
+			if err != nil {
+				return nil, err
+			}
+			if !exist {
+				return nil, fmt.Errorf("flow %d not exist", flowId)
+			}
+
+			flow, err = m.u.resolveSubFlows(ctx, flow)
+			if err != nil {
+				return nil, err
+			}
+			f, err := model.FlowFromModel(flow)
+			if err != nil {
+				return nil, err
+			}
+
+			subParams := map[string]interface{}{}
+			for k, v := range params {
+				if k != "flow_id" {
+					subParams[k] = v
+				}
+			}
+
+			parallel := getSubFlowParallel(ctx)
+			return m.u.getWriteFlow().ExecNode(withSubFlow(ctx, flowId, parallel), f, subParams, parallel)
+		}),
+	}
+}
+
+// resolveSubFlows 返回 flow 的副本，其中 sub_flow 节点的输出由它调用的流程的输出节点得到，
+// 这样连接到 sub_flow 的节点也能检查类型。找不到的流程保持动态输出，运行时再报错。
+func (u *Flow) resolveSubFlows(ctx context.Context, flow *model.Flow) (*model.Flow, error) {
+	var nodes model.Nodes
+	for i, node := range flow.Graph.Nodes {
+		if model.NodeCmd(node) != "sub_flow" {
+			continue
+		}
+		flowId := cast.ToInt64(node.Data.GetInputValue("flow_id"))
+		if flowId == 0 {
+			continue
+		}
+		sub, exist, err := u.flowRepo.GetFlowById(ctx, flowId)
+		if err != nil {
+			return nil, err
+		}
+		if !exist {
+			continue
+		}
+		outputs, ok := subFlowOutputs(sub)
+		if !ok {
+			continue
+		}
+
+		if nodes == nil {
+			nodes = append(model.Nodes{}, flow.Graph.Nodes...)
+		}
+		nodes[i].Data.DynamicOutput = false
+		nodes[i].Data.OutputAnchors = outputs
+	}
+	if nodes == nil {
+		return flow, nil
+	}
+
+	c := *flow
+	c.Graph.Nodes = nodes
+	return &c, nil
+}
+
+// subFlowOutputs 返回流程输出节点的输出定义。
+// 输出节点（_output）的输出就是它的输入，所以没有声明输出时使用它的输入（不包括 _enable 等内部输入）。
+func subFlowOutputs(flow *model.Flow) ([]writeflow.NodeOutputAnchor, bool) {
+	node, ok := flow.Graph.Nodes.FindById(flow.Graph.GetOutputNodeId())
+	if !ok {
+		return nil, false
+	}
+	if !node.Data.DynamicOutput && len(node.Data.OutputAnchors) != 0 {
+		return node.Data.OutputAnchors, true
+	}
+	if model.NodeCmd(*node) != "_output" {
+		return nil, false
+	}
+
+	outputs := []writeflow.NodeOutputAnchor{}
+	for _, input := range node.Data.InputParams {
+		if strings.HasPrefix(input.Key, "_") {
+			continue
+		}
+		outputs = append(outputs, writeflow.NodeOutputAnchor{
+			Name: input.Name,
+			Key:  input.Key,
+			Type: input.Type,
+			List: input.InputType == writeflow.NodeInputAnchor && input.List,
+		})
+	}
+	return outputs, true
+}
+
+type subFlowChainKey struct{}
+type subFlowParallelKey struct{}
+
+// withSubFlow 记录正在运行的流程，用于发现子流程的递归调用；同时记录运行的并发数，子流程使用相同的并发数
+func withSubFlow(ctx context.Context, flowId int64, parallel int) context.Context {
+	if parallel > 0 {
+		ctx = context.WithValue(ctx, subFlowParallelKey{}, parallel)
+	}
+	if flowId == 0 {
+		return ctx
+	}
+	chain := getSubFlowChain(ctx)
+	return context.WithValue(ctx, subFlowChainKey{}, append(chain[:len(chain):len(chain)], flowId))
+}
+
+func getSubFlowParallel(ctx context.Context) int {
+	parallel, _ := ctx.Value(subFlowParallelKey{}).(int)
+	if parallel <= 0 {
+		return subFlowParallel
+	}
+	return parallel
+}
+
+func getSubFlowChain(ctx context.Context) []int64 {
+	chain, _ := ctx.Value(subFlowChainKey{}).([]int64)
+	return chain
+}
@@ -0,0 +1,126 @@
+package usecase
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/zbysir/writeflow/internal/model"
+	"github.com/zbysir/writeflow/pkg/writeflow"
+	"sync"
+	"testing"
+)
+
+func anchorInput(key string, typ string, nodeId string) writeflow.NodeInputParam {
+	return writeflow.NodeInputParam{Key: key, Type: typ, InputType: writeflow.NodeInputAnchor, Anchors: []writeflow.NodeAnchorTarget{{NodeId: nodeId, OutputKey: "default"}}}
+}
+
+func outputNode(inputs ...writeflow.NodeInputParam) model.Node {
+	return model.Node{Id: "OUTPUT", Type: "output", Data: writeflow.ComponentData{
+		Source:      writeflow.ComponentSource{CmdType: writeflow.BuiltInCmd, BuiltinCmd: "_output"},
+		InputParams: append([]writeflow.NodeInputParam{{Key: "_enable", Type: "bool", InputType: writeflow.NodeInputLiteral, Value: true}}, inputs...),
+	}}
+}
+
+func subFlowNode(id string, flowId int64, inputs ...writeflow.NodeInputParam) model.Node {
+	return model.Node{Id: id, Type: "sub_flow", Data: writeflow.ComponentData{
+		Source:        writeflow.ComponentSource{CmdType: writeflow.BuiltInCmd, BuiltinCmd: "sub_flow"},
+		DynamicInput:  true,
+		DynamicOutput: true,
+		InputParams:   append([]writeflow.NodeInputParam{{Key: "flow_id", Type: "int", InputType: writeflow.NodeInputLiteral, Value: flowId}}, inputs...),
+	}}
+}
+
+// createChildFlow 创建一个读取参数 name 并输出 result: "hi <name>" 的流程
+func createChildFlow(t *testing.T, u *Flow) int64 {
+	tpl := textNode("t", writeflow.NodeInputParam{Key: "template", InputType: writeflow.NodeInputLiteral, Value: "hi {{.p.name}}"})
+	tpl.Data.InputParams = append(tpl.Data.InputParams, anchorInput("p", "any", "p"))
+
+	id, err := u.flowRepo.CreateFlow(context.Background(), &model.Flow{Name: "child", Graph: model.Graph{Nodes: model.Nodes{
+		{Id: "p", Type: "params", Data: writeflow.ComponentData{
+			Source:        writeflow.ComponentSource{CmdType: writeflow.BuiltInCmd, BuiltinCmd: "_params"},
+			OutputAnchors: []writeflow.NodeOutputAnchor{{Key: "default", Type: "any"}},
+		}},
+		tpl,
+		outputNode(anchorInput("result", "string", "t")),
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestSubFlow(t *testing.T) {
+	u, _ := newTestFlow(t)
+	ctx := context.Background()
+	childId := createChildFlow(t, u)
+
+	parent := &model.Flow{Id: childId + 1, Graph: model.Graph{Nodes: model.Nodes{
+		subFlowNode("s", childId, writeflow.NodeInputParam{Key: "name", Type: "string", InputType: writeflow.NodeInputLiteral, Value: "bysir"}),
+		outputNode(writeflow.NodeInputParam{Key: "value", Type: "string", InputType: writeflow.NodeInputAnchor, Anchors: []writeflow.NodeAnchorTarget{{NodeId: "s", OutputKey: "result"}}}),
+	}}}
+
+	// sub_flow 节点的输出由子流程的输出节点得到
+	resolved, err := u.resolveSubFlows(ctx, parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := resolved.Graph.Nodes.FindById("s")
+	assert.False(t, s.Data.DynamicOutput)
+	assert.Equal(t, []writeflow.NodeOutputAnchor{{Key: "result", Type: "string"}}, s.Data.OutputAnchors)
+	// 不修改传入的流程
+	s, _ = parent.Graph.Nodes.FindById("s")
+	assert.True(t, s.Data.DynamicOutput)
+
+	// 子流程的节点状态嵌套在 sub_flow 节点下
+	var lock sync.Mutex
+	nodeIds := map[string]bool{}
+	rsp, err := u.RunFlowByDetailSync(writeflow.WithStatusReporter(ctx, func(s writeflow.NodeStatusLog) {
+		lock.Lock()
+		nodeIds[s.NodeId] = true
+		lock.Unlock()
+	}), parent, nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "hi bysir", rsp["value"])
+	assert.True(t, nodeIds["s/t"])
+	assert.True(t, nodeIds["s/OUTPUT"])
+
+	// 输出的类型会被检查
+	parent.Graph.Nodes[1] = outputNode(writeflow.NodeInputParam{Key: "value", Type: "[]string", InputType: writeflow.NodeInputAnchor, Anchors: []writeflow.NodeAnchorTarget{{NodeId: "s", OutputKey: "result"}}})
+	_, err = u.RunFlowByDetailSync(ctx, parent, nil, 2)
+	assert.ErrorContains(t, err, "cannot connect output 'result' of node 's'")
+
+	// 不存在的流程
+	_, err = u.RunFlowByDetailSync(ctx, &model.Flow{Graph: model.Graph{Nodes: model.Nodes{
+		subFlowNode("s", 1000),
+		outputNode(anchorInput("value", "any", "s")),
+	}}}, nil, 2)
+	assert.ErrorContains(t, err, "flow 1000 not exist")
+
+	// 子流程使用父流程的并发数
+	assert.Equal(t, subFlowParallel, getSubFlowParallel(ctx))
+	assert.Equal(t, 8, getSubFlowParallel(withSubFlow(ctx, childId, 8)))
+}
+
+func TestSubFlowRecursion(t *testing.T) {
+	u, _ := newTestFlow(t)
+	ctx := context.Background()
+
+	f := &model.Flow{Name: "self"}
+	id, err := u.flowRepo.CreateFlow(ctx, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Id = id
+	f.Graph = model.Graph{Nodes: model.Nodes{
+		subFlowNode("s", id),
+		outputNode(anchorInput("value", "any", "s")),
+	}}
+	err = u.flowRepo.UpdateFlow(ctx, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = u.RunFlowSync(ctx, id, 0, nil, 2, "")
+	assert.ErrorContains(t, err, "sub flow recursion")
+}
@@ -83,3 +83,25 @@ type ComponentData struct {
 	InputParams   []NodeInputParam   `json:"input_params,omitempty"`   // 字面参数定义
 	OutputAnchors []NodeOutputAnchor `json:"output_anchors,omitempty"` // 输出锚点定义
 }
+
+// ExecPolicyInputParams 是 writeflow 的保留输入，用于配置节点的超时与重试，组件可以直接添加到 InputParams 中
+var ExecPolicyInputParams = []NodeInputParam{
+	{
+		Name:     Locales{"zh-CN": "超时时间（如 10s）"},
+		Key:      "_timeout",
+		Type:     "string",
+		Optional: true,
+	},
+	{
+		Name:     Locales{"zh-CN": "重试次数"},
+		Key:      "_retry",
+		Type:     "number",
+		Optional: true,
+	},
+	{
+		Name:     Locales{"zh-CN": "重试间隔（如 1s，每次翻倍）"},
+		Key:      "_retry_backoff",
+		Type:     "string",
+		Optional: true,
+	},
+}
@@ -10,8 +10,6 @@ import (
 	"io/ioutil"
 	"net/http"
 	"reflect"
-	"regexp"
-	"strings"
 	"time"
 )
 
@@ -191,7 +189,7 @@ func (b *Builtin) Components() []writeflow.Component {
 					CmdType:    writeflow.BuiltInCmd,
 					BuiltinCmd: "call_http",
 				},
-				InputParams: []writeflow.NodeInputParam{
+				InputParams: append([]writeflow.NodeInputParam{
 					{
 						Name: map[string]string{
 							"zh-CN": "URL",
@@ -218,7 +216,7 @@ func (b *Builtin) Components() []writeflow.Component {
 						Type:     "any",
 						Optional: true,
 					},
-				},
+				}, writeflow.ExecPolicyInputParams()...),
 				OutputAnchors: []writeflow.NodeOutputAnchor{
 					{
 						Name: map[string]string{
@@ -263,6 +261,13 @@ func (b *Builtin) Components() []writeflow.Component {
 						Key:  "default",
 						Type: "any",
 					},
+					{
+						Name: map[string]string{
+							"zh-CN": "Branch",
+						},
+						Key:  "branch",
+						Type: "string",
+					},
 				},
 			},
 		},
@@ -274,6 +279,9 @@ func (b *Builtin) Components() []writeflow.Component {
 				Name: map[string]string{
 					"zh-CN": "For",
 				},
+				Description: map[string]string{
+					"zh-CN": "循环体节点输出 _for_break 为 continue 时跳过当前项，为 break 时跳过当前项并结束循环",
+				},
 				Source: writeflow.ComponentSource{
 					CmdType:    writeflow.BuiltInCmd,
 					BuiltinCmd: "_for",
@@ -295,6 +303,89 @@ func (b *Builtin) Components() []writeflow.Component {
 						Key:  "item",
 						Type: "any",
 					},
+					{
+						Name: map[string]string{
+							"zh-CN": "并发数",
+						},
+						Key:      "concurrency",
+						Type:     "number",
+						Value:    1,
+						Optional: true,
+					},
+				},
+				OutputAnchors: []writeflow.NodeOutputAnchor{
+					{
+						Name: map[string]string{
+							"zh-CN": "Item",
+						},
+						Key:  "item",
+						Type: "any",
+					},
+					{
+						Name: map[string]string{
+							"zh-CN": "Index",
+						},
+						Key:  "index",
+						Type: "number",
+					},
+					{
+						Name: map[string]string{
+							"zh-CN": "Default",
+						},
+						Key:  "default",
+						Type: "any",
+					},
+				},
+			},
+		},
+		{
+			Id:       0,
+			Type:     "while",
+			Category: "logic",
+			Data: writeflow.ComponentData{
+				Name: map[string]string{
+					"zh-CN": "While",
+				},
+				Description: map[string]string{
+					"zh-CN": "重复执行循环体直到条件不成立，Item 为上一次的结果（第一次为 Init），条件中可以使用 data（本次的结果）与 index",
+				},
+				Source: writeflow.ComponentSource{
+					CmdType:    writeflow.BuiltInCmd,
+					BuiltinCmd: "_while",
+				},
+				InputParams: []writeflow.NodeInputParam{
+					{
+						InputType: writeflow.NodeInputAnchor,
+						Name: map[string]string{
+							"zh-CN": "Init",
+						},
+						Key:      "init",
+						Type:     "any",
+						Optional: true,
+					},
+					{
+						InputType: writeflow.NodeInputAnchor,
+						Name: map[string]string{
+							"zh-CN": "Body",
+						},
+						Key:  "body",
+						Type: "any",
+					},
+					{
+						Name: map[string]string{
+							"zh-CN": "条件",
+						},
+						Key:  "condition",
+						Type: "string",
+					},
+					{
+						Name: map[string]string{
+							"zh-CN": "最大迭代次数",
+						},
+						Key:   "max_iterations",
+						Type:  "number",
+						Value: 10,
+					},
 				},
 				OutputAnchors: []writeflow.NodeOutputAnchor{
 					{
@@ -304,6 +395,155 @@ func (b *Builtin) Components() []writeflow.Component {
 						Key:  "item",
 						Type: "any",
 					},
+					{
+						Name: map[string]string{
+							"zh-CN": "Index",
+						},
+						Key:  "index",
+						Type: "number",
+					},
+					{
+						Name: map[string]string{
+							"zh-CN": "Default",
+						},
+						Key:  "default",
+						Type: "any",
+					},
+					{
+						Name: map[string]string{
+							"zh-CN": "迭代次数",
+						},
+						Key:  "iterations",
+						Type: "number",
+					},
+				},
+			},
+		},
+		{
+			Id:       0,
+			Type:     "try",
+			Category: "logic",
+			Data: writeflow.ComponentData{
+				Name: map[string]string{
+					"zh-CN": "Try",
+				},
+				Description: map[string]string{
+					"zh-CN": "Try 执行失败时使用 Fallback 的结果，Error 输出错误信息",
+				},
+				Source: writeflow.ComponentSource{
+					CmdType:    writeflow.BuiltInCmd,
+					BuiltinCmd: "_try",
+				},
+				InputParams: []writeflow.NodeInputParam{
+					{
+						InputType: writeflow.NodeInputAnchor,
+						Name: map[string]string{
+							"zh-CN": "Try",
+						},
+						Key:  "try",
+						Type: "any",
+					},
+					{
+						InputType: writeflow.NodeInputAnchor,
+						Name: map[string]string{
+							"zh-CN": "Fallback",
+						},
+						Key:      "fallback",
+						Type:     "any",
+						Optional: true,
+					},
+				},
+				OutputAnchors: []writeflow.NodeOutputAnchor{
+					{
+						Name: map[string]string{
+							"zh-CN": "Default",
+						},
+						Key:  "default",
+						Type: "any",
+					},
+					{
+						Name: map[string]string{
+							"zh-CN": "Error",
+						},
+						Key:  "error",
+						Type: "string",
+					},
+				},
+			},
+		},
+		{
+			Id:       0,
+			Type:     "race",
+			Category: "logic",
+			Data: writeflow.ComponentData{
+				Name: map[string]string{
+					"zh-CN": "Race",
+				},
+				Description: map[string]string{
+					"zh-CN": "同时执行所有输入，使用第一个成功的结果，其他输入会被取消",
+				},
+				Source: writeflow.ComponentSource{
+					CmdType:    writeflow.BuiltInCmd,
+					BuiltinCmd: "_race",
+				},
+				DynamicInput: true,
+				InputParams: []writeflow.NodeInputParam{
+					{
+						InputType: writeflow.NodeInputAnchor,
+						Name: map[string]string{
+							"zh-CN": "Default",
+						},
+						Key:  "default",
+						Type: "any",
+						List: true,
+					},
+				},
+				OutputAnchors: []writeflow.NodeOutputAnchor{
+					{
+						Name: map[string]string{
+							"zh-CN": "Default",
+						},
+						Key:  "default",
+						Type: "any",
+					},
+					{
+						Name: map[string]string{
+							"zh-CN": "Winner",
+						},
+						Key:  "winner",
+						Type: "string",
+					},
+				},
+			},
+		},
+		{
+			Id:       0,
+			Type:     "coalesce",
+			Category: "logic",
+			Data: writeflow.ComponentData{
+				Name: map[string]string{
+					"zh-CN": "Coalesce",
+				},
+				Description: map[string]string{
+					"zh-CN": "按顺序执行输入，使用第一个不为空的结果",
+				},
+				Source: writeflow.ComponentSource{
+					CmdType:    writeflow.BuiltInCmd,
+					BuiltinCmd: "_coalesce",
+				},
+				DynamicInput: true,
+				InputParams: []writeflow.NodeInputParam{
+					{
+						InputType: writeflow.NodeInputAnchor,
+						Name: map[string]string{
+							"zh-CN": "Default",
+						},
+						Key:  "default",
+						Type: "any",
+						List: true,
+					},
+				},
+				OutputAnchors: []writeflow.NodeOutputAnchor{
 					{
 						Name: map[string]string{
 							"zh-CN": "Default",
@@ -413,6 +653,26 @@ func Exec(ctx context.Context, params map[string]interface{}) (rsp map[string]in
 						Optional:    false,
 						Value:       `function exec (params){return params}`,
 					},
+					{
+						InputType: writeflow.NodeInputLiteral,
+						Name: map[string]string{
+							"zh-CN": "最大调用栈深度",
+							"en":    "Max call stack size",
+						},
+						Key:      "max_call_stack_size",
+						Type:     "int",
+						Optional: true,
+					},
+					{
+						InputType: writeflow.NodeInputLiteral,
+						Name: map[string]string{
+							"zh-CN": "超时时间（秒）",
+							"en":    "Timeout (seconds)",
+						},
+						Key:      "timeout",
+						Type:     "int",
+						Optional: true,
+					},
 				},
 				DynamicInput:  true,
 				DynamicOutput: true,
@@ -571,6 +831,9 @@ func Exec(ctx context.Context, params map[string]interface{}) (rsp map[string]in
 				Name: map[string]string{
 					"zh-CN": "模板文本",
 				},
+				Description: map[string]string{
+					"zh-CN": "使用输入渲染模板。expr: {{ data.name }}；go: Go text/template，如 {{ range .items }}{{ . | upper }}{{ end }}；jinja: 类 Jinja 语法，如 {% for i in items %}{{ i }}{% endfor %}",
+				},
 				Source: writeflow.ComponentSource{
 					CmdType:    writeflow.BuiltInCmd,
 					BuiltinCmd: "template_text",
@@ -579,6 +842,18 @@ func Exec(ctx context.Context, params map[string]interface{}) (rsp map[string]in
 				// dynamic input
 				DynamicInput: true,
 				InputParams: []writeflow.NodeInputParam{
+					{
+						InputType: writeflow.NodeInputLiteral,
+						Name: map[string]string{
+							"zh-CN": "语法",
+						},
+						Key:         "mode",
+						Type:        "string",
+						DisplayType: "select",
+						Options:     templateModes,
+						Optional:    true,
+						Value:       TemplateModeExpr,
+					},
 					{
 						Name: map[string]string{
 							"zh-CN": "模板",
@@ -596,6 +871,68 @@ func Exec(ctx context.Context, params map[string]interface{}) (rsp map[string]in
 				},
 			},
 		},
+		{
+			Type:     "text_transform",
+			Category: "text",
+			Data: writeflow.ComponentData{
+				Name: map[string]string{
+					"zh-CN": "文本处理",
+					"en":    "Text Transform",
+				},
+				Description: map[string]string{
+					"zh-CN": "处理文本，输入是流（如 LLM 的输出）时流式输出",
+				},
+				Source: writeflow.ComponentSource{
+					CmdType:    writeflow.BuiltInCmd,
+					BuiltinCmd: "text_transform",
+				},
+				InputParams: []writeflow.NodeInputParam{
+					{
+						InputType: writeflow.NodeInputAnchor,
+						Name: map[string]string{
+							"zh-CN": "文本",
+						},
+						Key:  "default",
+						Type: "string",
+					},
+					{
+						InputType: writeflow.NodeInputLiteral,
+						Name: map[string]string{
+							"zh-CN": "处理",
+						},
+						Key:         "op",
+						Type:        "string",
+						DisplayType: "select",
+						Options:     textTransformOps,
+						Value:       TextTransformTrim,
+					},
+					{
+						InputType: writeflow.NodeInputLiteral,
+						Name: map[string]string{
+							"zh-CN": "查找（replace）",
+						},
+						Key:      "old",
+						Type:     "string",
+						Optional: true,
+					},
+					{
+						InputType: writeflow.NodeInputLiteral,
+						Name: map[string]string{
+							"zh-CN": "替换为（replace）",
+						},
+						Key:      "new",
+						Type:     "string",
+						Optional: true,
+					},
+				},
+				OutputAnchors: []writeflow.NodeOutputAnchor{
+					{
+						Key:  "default",
+						Type: "string",
+					},
+				},
+			},
+		},
 	}
 }
 
@@ -617,31 +954,23 @@ func (b *Builtin) Cmd() map[string]writeflow.CMDer {
 		"record": writeflow.NewFun(func(ctx context.Context, params map[string]interface{}) (rsp map[string]interface{}, err error) {
 			return map[string]interface{}{"default": params}, nil
 		}),
-		"template_text": writeflow.NewFun(func(ctx context.Context, params map[string]interface{}) (rsp map[string]interface{}, err error) {
-			tpl := params["template"].(string)
-
-			// match {{abc}}
-			reg := regexp.MustCompile(`{{.+?}}`)
-			s := reg.ReplaceAllStringFunc(tpl, func(s string) string {
-				if err != nil {
-					return ""
-				}
-				s = strings.TrimPrefix(s, "{{")
-				s = strings.TrimSuffix(s, "}}")
-				s = strings.TrimSpace(s)
-				r, e := writeflow.LookInterface(params, s)
-				if e != nil {
-					err = fmt.Errorf("exec template exp '%s' error: %w", s, e)
-					return ""
-				}
-				return fmt.Sprintf("%v", r)
-			})
+		"template_text": writeflow.NewStreamFun(func(ctx context.Context, params map[string]interface{}) (rsp map[string]interface{}, err error) {
+			tpl := cast.ToString(params["template"])
+			s, err := renderTemplate(cast.ToString(params["mode"]), tpl, params)
 			if err != nil {
 				return nil, err
 			}
 
 			return map[string]interface{}{"default": s}, nil
+		}, func(ctx context.Context, params writeflow.Map) (<-chan writeflow.StreamChunk, error) {
+			// 输入中有流（如 LLM 的输出）时流式输出
+			tpl, err := writeflow.ReadStream(params["template"])
+			if err != nil {
+				return nil, err
+			}
+			return streamTemplate(ctx, cast.ToString(params["mode"]), cast.ToString(tpl), params)
 		}),
+		"text_transform": textTransformCmd(),
 		// 通过路径选择入参返回
 		"select": writeflow.NewFun(func(ctx context.Context, params map[string]interface{}) (rsp map[string]interface{}, err error) {
 			//log.Infof("select params: %+v", params)
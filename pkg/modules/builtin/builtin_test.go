@@ -2,7 +2,10 @@ package builtin
 
 import (
 	"context"
+	"github.com/flosch/pongo2/v6"
 	"github.com/stretchr/testify/assert"
+	"github.com/zbysir/writeflow/pkg/writeflow"
+	"strings"
 	"testing"
 )
 
@@ -32,6 +35,179 @@ func TestTemplateText(t *testing.T) {
 	assert.Equal(t, "hello bysir", r["default"])
 }
 
+func TestTemplateTextMode(t *testing.T) {
+	params := map[string]interface{}{
+		"name":  "bysir",
+		"items": []interface{}{"a", "b"},
+	}
+	cases := []struct {
+		mode   string
+		tpl    string
+		expect string
+	}{
+		{mode: "expr", tpl: "hello {{ upper(name) }}", expect: ""},
+		{mode: "expr", tpl: "hello {{ lower(name) }}, {{ len(items) }}", expect: "hello bysir, 2"},
+		{mode: "go", tpl: "{{ range $i, $v := .items }}{{ $i }}.{{ $v | upper }} {{ end }}{{ .items | join \", \" }}", expect: "0.A 1.B a, b"},
+		{mode: "go", tpl: "{{ if .none }}x{{ else }}{{ .none | default \"y\" }}{{ end }}", expect: "y"},
+		{mode: "jinja", tpl: "{% for i in items %}\n- {{ i|upper }}\n{% endfor %}{{ name }} <{{ items|wf_tojson }}>", expect: "- A\n- B\nbysir <[\"a\",\"b\"]>"},
+		{mode: "jinja", tpl: "{% include \"/etc/hosts\" %}", expect: ""},
+		// 不转义，保留开头的换行与结尾的空格
+		{mode: "jinja", tpl: "\n{% if name %}<{{ name }}>{% endif %}  ", expect: "\n<bysir>  "},
+		{mode: "jinja", tpl: "\n\n  {{ name }}\t ", expect: "\n\n  bysir\t "},
+		{mode: "go", tpl: "{{ \"hello wORLD\" | title }}", expect: "Hello WORLD"},
+		{mode: "unknown", tpl: "a", expect: ""},
+	}
+	for _, c := range cases {
+		p := map[string]interface{}{"template": c.tpl, "mode": c.mode}
+		for k, v := range params {
+			p[k] = v
+		}
+		r, err := New().Cmd()["template_text"].Exec(context.Background(), p)
+		if c.expect == "" {
+			assert.Error(t, err, c.tpl)
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, c.expect, r["default"], c.tpl)
+	}
+}
+
+func TestJinjaAutoescape(t *testing.T) {
+	_, err := renderJinjaTemplate("{{ a }}", map[string]interface{}{"a": "<b>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 不修改 pongo2 全局的 autoescape
+	tpl, err := pongo2.FromString("{{ a }}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := tpl.Execute(pongo2.Context{"a": "<b>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "&lt;b&gt;", s)
+}
+
+func TestTemplateTextError(t *testing.T) {
+	cases := []struct {
+		mode   string
+		tpl    string
+		line   int
+		column int
+	}{
+		{mode: "expr", tpl: "a\nb {{ data. }}", line: 2, column: 3},
+		{mode: "go", tpl: "a\n{{ if .a }}", line: 2},
+		{mode: "go", tpl: "a\n  {{ .a.b.c }}", line: 2, column: 7},
+		{mode: "jinja", tpl: "a\n\n {{ a|nofilter }}", line: 3, column: 7},
+		{mode: "jinja", tpl: "a\n {% for i in items %}", line: 2, column: 20},
+		{mode: "jinja", tpl: "  {{ a|date:\"2006\" }}", line: 1, column: 8},
+	}
+	for _, c := range cases {
+		_, err := New().Cmd()["template_text"].Exec(context.Background(), map[string]interface{}{
+			"template": c.tpl,
+			"mode":     c.mode,
+			"a":        map[string]interface{}{"b": 1},
+		})
+		e := writeflow.NewExecNodeError(err, "tpl")
+		assert.Equal(t, c.line, e.Line, c.tpl)
+		if c.column != 0 {
+			assert.Equal(t, c.column, e.Column, c.tpl)
+		}
+		t.Log(e)
+	}
+}
+
+func newTestStream(chunks ...string) *writeflow.Stream {
+	s := writeflow.NewStream()
+	for _, c := range chunks {
+		s.Append(c)
+	}
+	s.Close(nil)
+	return s
+}
+
+func readChunks(t *testing.T, ch <-chan writeflow.StreamChunk, err error) []string {
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ts []string
+	for c := range ch {
+		if c.Err != nil {
+			t.Fatal(c.Err)
+		}
+		ts = append(ts, c.Text)
+	}
+	return ts
+}
+
+func TestTemplateTextStream(t *testing.T) {
+	cmd := New().Cmd()["template_text"].(writeflow.StreamCMDer)
+	cases := []struct {
+		mode   string
+		tpl    string
+		expect []string
+	}{
+		{mode: "expr", tpl: "Q: {{ q }}\nA: {{ answer }}!", expect: []string{"Q: why\nA: ", "Hello ", "World", "!"}},
+		{mode: "go", tpl: "{{ .answer }}|{{ .answer }}", expect: []string{"Hello ", "World", "|", "Hello ", "World"}},
+		{mode: "jinja", tpl: "{% if q %}{{ answer }}{% endif %}", expect: []string{"Hello ", "World"}},
+		// 流被函数处理时无法流式输出
+		{mode: "expr", tpl: "{{ lower(answer) }}", expect: []string{"hello world"}},
+		{mode: "jinja", tpl: "{{ answer|upper }}.", expect: []string{"HELLO WORLD."}},
+	}
+	for _, c := range cases {
+		ch, err := cmd.ExecStream(context.Background(), map[string]interface{}{
+			"template": c.tpl,
+			"mode":     c.mode,
+			"q":        "why",
+			"answer":   newTestStream("Hello ", "World"),
+		})
+		assert.Equal(t, c.expect, readChunks(t, ch, err), c.tpl)
+	}
+
+	_, err := cmd.ExecStream(context.Background(), map[string]interface{}{
+		"template": "{{ if }}",
+		"mode":     "go",
+		"answer":   newTestStream("a"),
+	})
+	assert.Error(t, err)
+}
+
+func TestTextTransform(t *testing.T) {
+	cmd := New().Cmd()["text_transform"].(writeflow.StreamCMDer)
+	cases := []struct {
+		params map[string]interface{}
+		chunks []string
+		expect string
+	}{
+		{params: map[string]interface{}{"op": "trim"}, chunks: []string{"  \n", " hello", " ", "world  ", "\n "}, expect: "hello world"},
+		{params: map[string]interface{}{"op": "upper"}, chunks: []string{"hello ", "world"}, expect: "HELLO WORLD"},
+		{params: map[string]interface{}{"op": "replace", "old": "</think>", "new": ""}, chunks: []string{"a</th", "ink>b</", "think", ">c</"}, expect: "abc</"},
+		{params: map[string]interface{}{"op": "replace", "old": "你好", "new": "hi"}, chunks: []string{"说你", "好吧你"}, expect: "说hi吧你"},
+	}
+	for _, c := range cases {
+		p := map[string]interface{}{"default": strings.Join(c.chunks, "")}
+		for k, v := range c.params {
+			p[k] = v
+		}
+		r, err := cmd.Exec(context.Background(), p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, c.expect, r["default"])
+
+		p["default"] = newTestStream(c.chunks...)
+		ch, err := cmd.ExecStream(context.Background(), p)
+		assert.Equal(t, c.expect, strings.Join(readChunks(t, ch, err), ""))
+	}
+
+	_, err := cmd.Exec(context.Background(), map[string]interface{}{"op": "reverse"})
+	assert.Error(t, err)
+}
+
 func TestCallHttp(t *testing.T) {
 	r, err := New().Cmd()["call_http"].Exec(context.Background(), map[string]interface{}{
 		"url":    "http://localhost:18002/rpc",
@@ -47,6 +223,21 @@ func TestCallHttp(t *testing.T) {
 	assert.NotEqual(t, nil, r["default"])
 }
 
+func TestCallHttpPolicyInputs(t *testing.T) {
+	for _, c := range New().Components() {
+		if c.Type != "call_http" {
+			continue
+		}
+		var keys []string
+		for _, p := range c.Data.InputParams {
+			keys = append(keys, p.Key)
+		}
+		assert.Subset(t, keys, []string{"_timeout", "_retry", "_retry_backoff"})
+		return
+	}
+	t.Fatal("call_http not found")
+}
+
 func TestSwitch(t *testing.T) {
 	r, err := New().Cmd()["switch"].Exec(context.Background(), map[string]interface{}{
 		"data": map[string]string{"a": "b"},
@@ -61,3 +252,50 @@ func TestSwitch(t *testing.T) {
 	assert.Equal(t, true, r["aaa"])
 	assert.Equal(t, nil, r["bbb"])
 }
+
+// 执行 cmd 之前的类型转换不改变已有流程的结果
+func TestBuiltinFlow(t *testing.T) {
+	wf := writeflow.NewWriteFlow()
+	wf.RegisterModule(New())
+
+	anchor := func(key string, dataType string, nodeId string) writeflow.NodeInput {
+		return writeflow.NodeInput{Key: key, Type: writeflow.NodeInputAnchor, DataType: dataType, Anchors: []writeflow.NodeAnchorTarget{{NodeId: nodeId, OutputKey: "default"}}}
+	}
+	literal := func(key string, dataType string, v interface{}) writeflow.NodeInput {
+		return writeflow.NodeInput{Key: key, Type: writeflow.NodeInputLiteral, DataType: dataType, Literal: v}
+	}
+	f := &writeflow.Flow{
+		Nodes: map[string]writeflow.Node{
+			"params": {Id: "params", Cmd: "_params"},
+			"user":   {Id: "user", Cmd: "select", Inputs: writeflow.NodeInputs{anchor("data", "any", "params"), literal("path", "string", "data.user")}},
+			"age":    {Id: "age", Cmd: "select", Inputs: writeflow.NodeInputs{anchor("data", "any", "params"), literal("path", "string", "data.age")}},
+			"text": {Id: "text", Cmd: "template_text", Inputs: writeflow.NodeInputs{
+				literal("mode", "string", "go"),
+				literal("template", "string", "{{.user.name}} is {{.age}}"),
+				anchor("user", "", "user"),
+				anchor("age", "", "age"),
+			}},
+			"trim": {Id: "trim", Cmd: "text_transform", Inputs: writeflow.NodeInputs{anchor("default", "string", "age"), literal("op", "string", "trim")}},
+			"output": {Id: "output", Cmd: "_output", Inputs: writeflow.NodeInputs{
+				literal("_enable", "bool", true),
+				anchor("text", "any", "text"),
+				anchor("age", "any", "trim"),
+				anchor("user", "any", "user"),
+			}},
+		},
+		OutputNodeId: "output",
+	}
+
+	rsp, err := wf.ExecNode(context.Background(), f, map[string]interface{}{
+		"user": map[string]interface{}{"name": "bysir"},
+		"age":  float64(18),
+	}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "bysir is 18", rsp["text"])
+	// 基本类型之间无损地转换
+	assert.Equal(t, "18", rsp["age"])
+	// 对象原样传递
+	assert.Equal(t, map[string]interface{}{"name": "bysir"}, rsp["user"])
+}
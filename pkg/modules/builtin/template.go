@@ -0,0 +1,388 @@
+package builtin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/flosch/pongo2/v6"
+	"github.com/google/uuid"
+	"github.com/spf13/cast"
+	"github.com/zbysir/writeflow/pkg/export"
+	"github.com/zbysir/writeflow/pkg/writeflow"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+	"io"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"unicode/utf8"
+)
+
+// template_text 支持的模板语法
+const (
+	TemplateModeExpr  = "expr"  // {{ 表达式 }}，见 writeflow.CompileExpression
+	TemplateModeGo    = "go"    // Go text/template，可以使用 templateFuncs 中的函数
+	TemplateModeJinja = "jinja" // 类 Jinja 语法（pongo2），常用于 LLM 的提示词
+)
+
+var templateModes = []string{TemplateModeExpr, TemplateModeGo, TemplateModeJinja}
+
+// renderTemplate 使用 params 作为变量渲染模板，模板错误会返回带有位置的 writeflow.PositionError
+func renderTemplate(mode string, tpl string, params map[string]interface{}) (string, error) {
+	switch mode {
+	case "", TemplateModeExpr:
+		return renderExprTemplate(tpl, params)
+	case TemplateModeGo:
+		return renderGoTemplate(tpl, params)
+	case TemplateModeJinja:
+		return renderJinjaTemplate(tpl, params)
+	default:
+		return "", fmt.Errorf("unsupported template mode '%s', should be one of %s", mode, strings.Join(templateModes, ", "))
+	}
+}
+
+var exprTemplateReg = regexp.MustCompile(`{{.+?}}`)
+
+func renderExprTemplate(tpl string, params map[string]interface{}) (string, error) {
+	var b strings.Builder
+	last := 0
+	for _, loc := range exprTemplateReg.FindAllStringIndex(tpl, -1) {
+		b.WriteString(tpl[last:loc[0]])
+		last = loc[1]
+
+		s := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(tpl[loc[0]:loc[1]], "{{"), "}}"))
+		r, err := writeflow.LookInterface(params, s)
+		if err != nil {
+			line, column := textPosition(tpl, loc[0])
+			return "", &writeflow.PositionError{Line: line, Column: column, Err: fmt.Errorf("exec template exp '%s' error: %w", s, err)}
+		}
+		b.WriteString(fmt.Sprintf("%v", r))
+	}
+	b.WriteString(tpl[last:])
+
+	return b.String(), nil
+}
+
+// textPosition 返回 offset 在 s 中的行与列
+func textPosition(s string, offset int) (line, column int) {
+	before := s[:offset]
+	line = strings.Count(before, "\n") + 1
+	column = utf8.RuneCountInString(before[strings.LastIndex(before, "\n")+1:]) + 1
+	return
+}
+
+// text/template 的错误格式如 template: template_text:2:5: executing ...，列可能没有
+var goTemplateErrReg = regexp.MustCompile(`(?s)^template: template_text:(\d+)(?::(\d+))?: (.*)$`)
+
+func renderGoTemplate(tpl string, params map[string]interface{}) (string, error) {
+	t, err := template.New("template_text").Funcs(templateFuncs).Parse(tpl)
+	if err != nil {
+		return "", goTemplateError(err)
+	}
+
+	var b bytes.Buffer
+	err = t.Execute(&b, params)
+	if err != nil {
+		return "", goTemplateError(err)
+	}
+	return b.String(), nil
+}
+
+func goTemplateError(err error) error {
+	m := goTemplateErrReg.FindStringSubmatch(err.Error())
+	if m == nil {
+		return err
+	}
+	line, _ := strconv.Atoi(m[1])
+	column, _ := strconv.Atoi(m[2])
+	return &writeflow.PositionError{Line: line, Column: column, Err: errors.New(m[3])}
+}
+
+// templateFuncs 是 go 模板中可以使用的函数，参数顺序与 sprig 一致，方便使用管道，如 {{ .list | join ", " }}
+var templateFuncs = template.FuncMap{
+	"upper":     strings.ToUpper,
+	"lower":     strings.ToLower,
+	"title":     func(s string) string { return cases.Title(language.Und, cases.NoLower).String(s) },
+	"trim":      strings.TrimSpace,
+	"repeat":    func(count int, s string) string { return strings.Repeat(s, count) },
+	"replace":   func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"contains":  func(substr, s string) bool { return strings.Contains(s, substr) },
+	"hasPrefix": func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix": func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"split":     func(sep, s string) []string { return strings.Split(s, sep) },
+	"join": func(sep string, list interface{}) (string, error) {
+		var ss []string
+		err := writeflow.ForInterface(list, func(i interface{}) {
+			ss = append(ss, cast.ToString(i))
+		})
+		return strings.Join(ss, sep), err
+	},
+	"quote": func(s interface{}) string { return strconv.Quote(cast.ToString(s)) },
+	"indent": func(spaces int, s string) string {
+		pad := strings.Repeat(" ", spaces)
+		return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+	},
+	"default": func(def interface{}, v interface{}) interface{} {
+		if v == nil {
+			return def
+		}
+		rv := reflect.ValueOf(v)
+		if rv.IsZero() || ((rv.Kind() == reflect.Slice || rv.Kind() == reflect.Map) && rv.Len() == 0) {
+			return def
+		}
+		return v
+	},
+	"toJson": func(v interface{}) (string, error) {
+		bs, err := json.Marshal(v)
+		return string(bs), err
+	},
+	"toPrettyJson": func(v interface{}) (string, error) {
+		bs, err := json.MarshalIndent(v, "", "  ")
+		return string(bs), err
+	},
+	"add": func(a, b interface{}) float64 { return cast.ToFloat64(a) + cast.ToFloat64(b) },
+	"sub": func(a, b interface{}) float64 { return cast.ToFloat64(a) - cast.ToFloat64(b) },
+}
+
+var (
+	jinjaSet     *pongo2.TemplateSet
+	jinjaSetErr  error
+	jinjaSetOnce sync.Once
+)
+
+// jinjaToJsonFilter 是将值转为 json 的过滤器，如 {{ items|wf_tojson }}。
+// pongo2 的过滤器是全局注册的，使用带前缀的名字避免与其他使用 pongo2 的代码冲突。
+const jinjaToJsonFilter = "wf_tojson"
+
+// noTemplateLoader 不允许模板读取文件
+type noTemplateLoader struct{}
+
+func (noTemplateLoader) Abs(base, name string) string {
+	return name
+}
+
+func (noTemplateLoader) Get(path string) (io.Reader, error) {
+	return nil, fmt.Errorf("template '%s' not found: loading templates is not supported", path)
+}
+
+func getJinjaSet() (*pongo2.TemplateSet, error) {
+	jinjaSetOnce.Do(func() {
+		err := pongo2.RegisterFilter(jinjaToJsonFilter, func(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+			bs, err := json.Marshal(in.Interface())
+			if err != nil {
+				return nil, &pongo2.Error{Sender: "filter:" + jinjaToJsonFilter, OrigError: err}
+			}
+			return pongo2.AsSafeValue(string(bs)), nil
+		})
+		if err != nil {
+			jinjaSetErr = fmt.Errorf("register jinja filter error: %w", err)
+			return
+		}
+
+		set := pongo2.NewSet("template_text", noTemplateLoader{})
+		for _, tag := range []string{"include", "ssi", "import", "extends"} {
+			_ = set.BanTag(tag)
+		}
+		set.Options.TrimBlocks = true
+		set.Options.LStripBlocks = true
+		jinjaSet = set
+	})
+	return jinjaSet, jinjaSetErr
+}
+
+// 模板用于生成文本而不是 html，默认不转义，需要时使用 escape 过滤器或 autoescape 标签。
+// pongo2 只能全局设置 autoescape，所以在每个模板外面包一层 autoescape off。
+// TrimBlocks / LStripBlocks 只处理与 {% %} 相邻的文本，包装的标签与模板之间隔着空的输出 {{ "" }}，
+// 这样模板开头的换行与结尾的空格不会因为包装的标签被去掉。
+const (
+	jinjaPrefix = `{% autoescape off %}{{ "" }}`
+	jinjaSuffix = `{{ "" }}{% endautoescape %}`
+)
+
+func renderJinjaTemplate(tpl string, params map[string]interface{}) (string, error) {
+	set, err := getJinjaSet()
+	if err != nil {
+		return "", err
+	}
+	// 先编译原始的模板，语法错误的位置与提示不受 autoescape 标签影响
+	_, err = set.FromString(tpl)
+	if err != nil {
+		return "", jinjaTemplateError(err, false)
+	}
+	t, err := set.FromString(jinjaPrefix + tpl + jinjaSuffix)
+	if err != nil {
+		return "", jinjaTemplateError(err, true)
+	}
+
+	ctx := pongo2.Context{}
+	for k, v := range params {
+		ctx[k] = v
+	}
+	s, err := t.Execute(ctx)
+	if err != nil {
+		return "", jinjaTemplateError(err, true)
+	}
+	return s, nil
+}
+
+// jinjaTemplateError 将 pongo2 的错误转为 writeflow.PositionError，wrapped 表示错误来自包了 jinjaPrefix 的模板
+func jinjaTemplateError(err error, wrapped bool) error {
+	var e *pongo2.Error
+	if !errors.As(err, &e) || e.Line <= 0 {
+		return err
+	}
+	msg := e.OrigError.Error()
+	if e.Token != nil {
+		msg = fmt.Sprintf("%s (near '%s')", msg, e.Token.Val)
+	}
+	column := e.Column
+	if wrapped && e.Line == 1 {
+		column -= utf8.RuneCountInString(jinjaPrefix)
+		if column < 1 {
+			column = 1
+		}
+	}
+	return &writeflow.PositionError{Line: e.Line, Column: column, Err: errors.New(msg)}
+}
+
+var (
+	directReferenceReg   = regexp.MustCompile(`{{-?\s*(\w+)\s*-?}}`)
+	goDirectReferenceReg = regexp.MustCompile(`{{-?\s*\.(\w+)\s*-?}}`)
+	wordReg              = regexp.MustCompile(`\w+`)
+	// 流在模板中的占位符，见 streamTemplate
+	placeholderReg = regexp.MustCompile("\x00(Wf[0-9a-f]+):(.*?)\x00")
+)
+
+// directReference 返回模板中是否只是直接输出了变量 key（如 {{ answer }}），而没有在其他地方使用它（如函数、过滤器、条件）
+func directReference(mode string, tpl string, key string) bool {
+	// key 不是单词时无法判断
+	if wordReg.FindString(key) != key {
+		return false
+	}
+
+	direct := directReferenceReg
+	if mode == TemplateModeGo {
+		direct = goDirectReferenceReg
+	}
+	directCount := 0
+	for _, m := range direct.FindAllStringSubmatch(tpl, -1) {
+		if m[1] == key {
+			directCount++
+		}
+	}
+	allCount := 0
+	for _, w := range wordReg.FindAllString(tpl, -1) {
+		if w == key {
+			allCount++
+		}
+	}
+	return directCount == allCount
+}
+
+// streamTemplate 流式渲染模板：输入中的流先替换为占位符渲染，再在结果中找到占位符，按顺序转发流的内容。
+// 只有流在模板中被直接输出（如 {{ answer }}）时才能流式输出，否则（如被函数或过滤器处理）会读取完所有流之后再渲染。
+func streamTemplate(ctx context.Context, mode string, tpl string, params map[string]interface{}) (<-chan writeflow.StreamChunk, error) {
+	nonce := "Wf" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	placeholder := func(key string) string {
+		return "\x00" + nonce + ":" + key + "\x00"
+	}
+
+	streaming := true
+	ps := map[string]interface{}{}
+	for k, v := range params {
+		if _, ok := v.(export.Stream); ok {
+			ps[k] = placeholder(k)
+			if k != "template" && !directReference(mode, tpl, k) {
+				streaming = false
+			}
+		} else {
+			ps[k] = v
+		}
+	}
+
+	type segment struct {
+		text   string
+		stream interface{} // 不为 nil 时转发流
+	}
+	s, err := renderTemplate(mode, tpl, ps)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []segment
+	if streaming {
+		last := 0
+		for _, m := range placeholderReg.FindAllStringSubmatchIndex(s, -1) {
+			if s[m[2]:m[3]] != nonce {
+				continue
+			}
+			segments = append(segments, segment{text: s[last:m[0]]}, segment{stream: params[s[m[4]:m[5]]]})
+			last = m[1]
+		}
+		segments = append(segments, segment{text: s[last:]})
+		// 占位符被修改过
+		for _, seg := range segments {
+			if strings.Contains(strings.ToLower(seg.text), strings.ToLower(nonce)) {
+				segments = nil
+				break
+			}
+		}
+	}
+
+	ch := make(chan writeflow.StreamChunk)
+	send := func(text string) error {
+		select {
+		case ch <- writeflow.StreamChunk{Text: text}:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	go func() {
+		defer close(ch)
+
+		var err error
+		if segments == nil {
+			// 无法流式输出，读取完所有流之后再渲染
+			ps := map[string]interface{}{}
+			for k, v := range params {
+				ps[k], err = writeflow.ReadStream(v)
+				if err != nil {
+					break
+				}
+			}
+			if err == nil {
+				var s string
+				s, err = renderTemplate(mode, tpl, ps)
+				if err == nil {
+					err = send(s)
+				}
+			}
+		} else {
+			for _, seg := range segments {
+				if seg.stream != nil {
+					err = writeflow.ForStream(ctx, seg.stream, send)
+				} else if seg.text != "" {
+					err = send(seg.text)
+				}
+				if err != nil {
+					break
+				}
+			}
+		}
+
+		if err != nil {
+			select {
+			case ch <- writeflow.StreamChunk{Err: err}:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return ch, nil
+}
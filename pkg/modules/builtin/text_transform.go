@@ -0,0 +1,172 @@
+package builtin
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/cast"
+	"github.com/zbysir/writeflow/pkg/writeflow"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// text_transform 支持的处理
+const (
+	TextTransformTrim    = "trim"
+	TextTransformUpper   = "upper"
+	TextTransformLower   = "lower"
+	TextTransformReplace = "replace" // 将 old 替换为 new
+)
+
+var textTransformOps = []string{TextTransformTrim, TextTransformUpper, TextTransformLower, TextTransformReplace}
+
+// textTransformer 增量处理文本，每次写入一段，返回可以输出的部分，Flush 返回剩余的部分
+type textTransformer interface {
+	Write(s string) string
+	Flush() string
+}
+
+func newTextTransformer(params map[string]interface{}) (textTransformer, error) {
+	switch op := cast.ToString(params["op"]); op {
+	case TextTransformTrim:
+		return &trimTransformer{}, nil
+	case TextTransformUpper:
+		return funcTransformer(strings.ToUpper), nil
+	case TextTransformLower:
+		return funcTransformer(strings.ToLower), nil
+	case TextTransformReplace:
+		old := cast.ToString(params["old"])
+		if old == "" {
+			return nil, fmt.Errorf("old is required")
+		}
+		return &replaceTransformer{old: old, new: cast.ToString(params["new"])}, nil
+	default:
+		return nil, fmt.Errorf("unsupported op '%s', should be one of %s", op, strings.Join(textTransformOps, ", "))
+	}
+}
+
+type funcTransformer func(s string) string
+
+func (f funcTransformer) Write(s string) string {
+	return f(s)
+}
+
+func (f funcTransformer) Flush() string {
+	return ""
+}
+
+// trimTransformer 去掉开头与结尾的空白，结尾的空白会暂存，直到后面出现其他字符
+type trimTransformer struct {
+	started bool
+	pending string
+}
+
+func (t *trimTransformer) Write(s string) string {
+	if !t.started {
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		if s == "" {
+			return ""
+		}
+		t.started = true
+	}
+
+	s = t.pending + s
+	trimmed := strings.TrimRightFunc(s, unicode.IsSpace)
+	t.pending = s[len(trimmed):]
+	return trimmed
+}
+
+func (t *trimTransformer) Flush() string {
+	return ""
+}
+
+// replaceTransformer 替换文本，结尾可能是 old 的一部分的内容会暂存，等待下一段
+type replaceTransformer struct {
+	old, new string
+	buf      string
+}
+
+func (r *replaceTransformer) Write(s string) string {
+	r.buf += s
+	var b strings.Builder
+	for {
+		i := strings.Index(r.buf, r.old)
+		if i < 0 {
+			break
+		}
+		b.WriteString(r.buf[:i])
+		b.WriteString(r.new)
+		r.buf = r.buf[i+len(r.old):]
+	}
+
+	// 保留 len(old)-1 个字节，不截断字符
+	cut := len(r.buf) - (len(r.old) - 1)
+	if cut < 0 {
+		cut = 0
+	}
+	for cut > 0 && cut < len(r.buf) && !utf8.RuneStart(r.buf[cut]) {
+		cut--
+	}
+	b.WriteString(r.buf[:cut])
+	r.buf = r.buf[cut:]
+	return b.String()
+}
+
+func (r *replaceTransformer) Flush() string {
+	s := r.buf
+	r.buf = ""
+	return s
+}
+
+func textTransformCmd() writeflow.StreamCMDer {
+	return writeflow.NewStreamFun(func(ctx context.Context, params map[string]interface{}) (rsp map[string]interface{}, err error) {
+		t, err := newTextTransformer(params)
+		if err != nil {
+			return nil, err
+		}
+		s := t.Write(cast.ToString(params["default"]))
+		return map[string]interface{}{"default": s + t.Flush()}, nil
+	}, func(ctx context.Context, params writeflow.Map) (<-chan writeflow.StreamChunk, error) {
+		// 只有 default 会被流式处理
+		for k, v := range params {
+			if k == "default" {
+				continue
+			}
+			v, err := writeflow.ReadStream(v)
+			if err != nil {
+				return nil, err
+			}
+			params[k] = v
+		}
+
+		t, err := newTextTransformer(params)
+		if err != nil {
+			return nil, err
+		}
+
+		ch := make(chan writeflow.StreamChunk)
+		send := func(c writeflow.StreamChunk) error {
+			if c.Text == "" && c.Err == nil {
+				return nil
+			}
+			select {
+			case ch <- c:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		go func() {
+			defer close(ch)
+			err := writeflow.ForStream(ctx, params["default"], func(text string) error {
+				return send(writeflow.StreamChunk{Text: t.Write(text)})
+			})
+			if err != nil {
+				_ = send(writeflow.StreamChunk{Err: err})
+				return
+			}
+			_ = send(writeflow.StreamChunk{Text: t.Flush()})
+		}()
+		return ch, nil
+	})
+}
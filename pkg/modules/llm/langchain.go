@@ -161,7 +161,7 @@ func (l *LangChain) Components() []export.Component {
 						Key:  "prompt",
 						Type: "string",
 					},
-				}, langchainCallInputParams...),
+				}, append(langchainCallInputParams, export.ExecPolicyInputParams...)...),
 				OutputAnchors: []export.NodeOutputAnchor{
 					{
 						Name: map[string]string{
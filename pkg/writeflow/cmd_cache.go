@@ -0,0 +1,124 @@
+package writeflow
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+)
+
+// CmdCache 是编译后的脚本 cmd（如 go_script / js_script）的 LRU 缓存，在多次运行之间共享。
+// key 是脚本内容的 hash（见 CmdCacheKey），脚本修改后 key 随之变化，旧的 cmd 会被自然淘汰。
+// 注意缓存的 cmd 会被并发执行：js 脚本每次执行使用新的运行时；go 脚本的解释器同时只被一次执行使用，
+// 但会被之后的执行复用（见 GoScriptCMD），全局变量的值可能会留给之后的执行。
+type CmdCache struct {
+	l        sync.Mutex
+	capacity int
+	ll       *list.List // 最近使用的在前面
+	items    map[string]*list.Element
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+type cmdCacheEntry struct {
+	key  string
+	cmd  CMDer
+	err  error
+	done chan struct{} // 编译完成后关闭，同一个脚本同时只会编译一次
+}
+
+// CmdCacheStats 用于诊断缓存的命中情况
+type CmdCacheStats struct {
+	Size      int    `json:"size"`
+	Capacity  int    `json:"capacity"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+}
+
+// DefaultCmdCache 是 FlowFromModel 使用的缓存
+var DefaultCmdCache = NewCmdCache(256)
+
+func NewCmdCache(capacity int) *CmdCache {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &CmdCache{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    map[string]*list.Element{},
+	}
+}
+
+// CmdCacheKey 使用 cmd 类型、编译选项与脚本内容生成缓存 key
+func CmdCacheKey(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Get 返回 key 对应的 cmd，不存在时调用 build 编译。编译失败的结果不会被缓存。
+func (c *CmdCache) Get(key string, build func() (CMDer, error)) (CMDer, error) {
+	c.l.Lock()
+	if el, ok := c.items[key]; ok {
+		c.ll.MoveToFront(el)
+		c.hits++
+		c.l.Unlock()
+
+		e := el.Value.(*cmdCacheEntry)
+		<-e.done
+		return e.cmd, e.err
+	}
+
+	c.misses++
+	e := &cmdCacheEntry{key: key, done: make(chan struct{})}
+	c.items[key] = c.ll.PushFront(e)
+	for c.ll.Len() > c.capacity {
+		c.removeElement(c.ll.Back())
+		c.evictions++
+	}
+	c.l.Unlock()
+
+	e.cmd, e.err = build()
+	close(e.done)
+
+	if e.err != nil {
+		c.l.Lock()
+		if el, ok := c.items[key]; ok && el.Value == e {
+			c.removeElement(el)
+		}
+		c.l.Unlock()
+	}
+
+	return e.cmd, e.err
+}
+
+func (c *CmdCache) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*cmdCacheEntry).key)
+}
+
+// Purge 清空缓存
+func (c *CmdCache) Purge() {
+	c.l.Lock()
+	defer c.l.Unlock()
+	c.ll.Init()
+	c.items = map[string]*list.Element{}
+}
+
+func (c *CmdCache) Stats() CmdCacheStats {
+	c.l.Lock()
+	defer c.l.Unlock()
+	return CmdCacheStats{
+		Size:      c.ll.Len(),
+		Capacity:  c.capacity,
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+	}
+}
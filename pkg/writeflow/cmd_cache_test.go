@@ -0,0 +1,65 @@
+package writeflow
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCmdCache(t *testing.T) {
+	c := NewCmdCache(2)
+	var builds int32
+	build := func(src string) func() (CMDer, error) {
+		return func() (CMDer, error) {
+			atomic.AddInt32(&builds, 1)
+			return NewJavaScriptCMD(src)
+		}
+	}
+
+	src := "function exec(params) {return {default: params.a}}"
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cmd, err := c.Get(CmdCacheKey("js_script", src), build(src))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			r, err := cmd.Exec(context.Background(), Map{"a": 1})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			assert.Equal(t, int64(1), r["default"])
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), builds)
+	assert.Equal(t, CmdCacheStats{Size: 1, Capacity: 2, Hits: 9, Misses: 1}, c.Stats())
+
+	// 修改脚本后重新编译，最久没有使用的被淘汰
+	for i := 0; i < 2; i++ {
+		s := fmt.Sprintf("function exec(params) {return {default: %d}}", i)
+		_, err := c.Get(CmdCacheKey("js_script", s), build(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	assert.Equal(t, int32(3), builds)
+	_, _ = c.Get(CmdCacheKey("js_script", src), build(src))
+	assert.Equal(t, int32(4), builds)
+	assert.Equal(t, uint64(2), c.Stats().Evictions)
+
+	// 编译失败不缓存
+	bad := "function exec(params) {"
+	_, err := c.Get(CmdCacheKey("js_script", bad), build(bad))
+	assert.Error(t, err)
+	_, err = c.Get(CmdCacheKey("js_script", bad), build(bad))
+	assert.Error(t, err)
+	assert.Equal(t, int32(6), builds)
+}
@@ -1,16 +1,38 @@
 package writeflow
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"github.com/traefik/yaegi/interp"
 	"github.com/traefik/yaegi/stdlib"
 	"github.com/zbysir/writeflow/pkg/writeflow/gosymbols"
+	"io"
 	"io/fs"
+	"os"
+	"sync"
 )
 
+// GoScriptCMD 执行 go 脚本，脚本中 fmt.Print* 输出的内容与 log 包输出到 Stderr 的内容会作为节点日志（os.Stdout 不会被捕获）。
+// 每个解释器同时只会被一次执行使用，这样输出才能对应到正在执行的节点。
+// 并发执行时按需编译新的解释器，最多 maxGoScriptInterps 个，之后的执行等待空闲的解释器，避免每次并发都重新编译。
 type GoScriptCMD struct {
-	innerCMD CMDer
+	fs     fs.FS
+	goPath string
+	src    string
+
+	idle    chan *goScriptInterp
+	l       sync.Mutex
+	created int // 已经编译的解释器数量
+}
+
+// maxGoScriptInterps 是每个脚本最多编译的解释器数量，即同一个脚本最多同时执行的数量
+const maxGoScriptInterps = 4
+
+type goScriptInterp struct {
+	exec   ExecFun
+	stdout *lineWriter
+	stderr *lineWriter
 }
 
 // src:
@@ -19,9 +41,25 @@ type GoScriptCMD struct {
 // func Exec(ctx context.Context, params []interface{}) (rsp []interface{}, err error) {}
 
 func NewGoScriptCMD(fs fs.FS, goPath string, src string) (*GoScriptCMD, error) {
+	g := &GoScriptCMD{fs: fs, goPath: goPath, src: src, idle: make(chan *goScriptInterp, maxGoScriptInterps)}
+	// 先编译一次，尽早发现脚本的错误
+	i, err := g.newInterp()
+	if err != nil {
+		return nil, err
+	}
+	g.created = 1
+	g.idle <- i
+	return g, nil
+}
+
+func (g *GoScriptCMD) newInterp() (*goScriptInterp, error) {
+	stdout := &lineWriter{fallback: os.Stdout}
+	stderr := &lineWriter{prefix: "[stderr] ", fallback: os.Stderr}
 	i := interp.New(interp.Options{
-		GoPath:               goPath,
-		SourcecodeFilesystem: fs,
+		GoPath:               g.goPath,
+		SourcecodeFilesystem: g.fs,
+		Stdout:               stdout,
+		Stderr:               stderr,
 	})
 
 	err := i.Use(stdlib.Symbols)
@@ -38,7 +76,7 @@ func NewGoScriptCMD(fs fs.FS, goPath string, src string) (*GoScriptCMD, error) {
 		return nil, err
 	}
 
-	_, err = i.Eval(src)
+	_, err = i.Eval(g.src)
 	if err != nil {
 		return nil, fmt.Errorf("failed to eval import: %w", err)
 	}
@@ -51,9 +89,104 @@ func NewGoScriptCMD(fs fs.FS, goPath string, src string) (*GoScriptCMD, error) {
 	config := execFun.Interface()
 
 	inner := config.(func(ctx context.Context, params map[string]interface{}) (rsp map[string]interface{}, err error))
-	return &GoScriptCMD{innerCMD: ExecFun(inner)}, nil
+	return &goScriptInterp{exec: inner, stdout: stdout, stderr: stderr}, nil
+}
+
+// acquire 取得一个空闲的解释器，没有空闲的解释器时编译新的，达到上限后等待其他执行释放
+func (g *GoScriptCMD) acquire(ctx context.Context) (*goScriptInterp, error) {
+	select {
+	case i := <-g.idle:
+		return i, nil
+	default:
+	}
+
+	g.l.Lock()
+	if g.created < maxGoScriptInterps {
+		g.created++
+		g.l.Unlock()
+		i, err := g.newInterp()
+		if err != nil {
+			g.l.Lock()
+			g.created--
+			g.l.Unlock()
+			return nil, err
+		}
+		return i, nil
+	}
+	g.l.Unlock()
+
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+	select {
+	case i := <-g.idle:
+		return i, nil
+	case <-done:
+		return nil, ctx.Err()
+	}
+}
+
+func (g *GoScriptCMD) release(i *goScriptInterp) {
+	g.idle <- i
 }
 
 func (g *GoScriptCMD) Exec(ctx context.Context, params Map) (rsp Map, err error) {
-	return g.innerCMD.Exec(ctx, params)
+	i, err := g.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := GetNodeLogger(ctx)
+	i.stdout.setLogger(logger)
+	i.stderr.setLogger(logger)
+	defer func() {
+		i.stdout.setLogger(nil)
+		i.stderr.setLogger(nil)
+		g.release(i)
+	}()
+
+	return i.exec.Exec(ctx, params)
+}
+
+// lineWriter 将写入的内容按行输出到 NodeLogger，没有 logger 时（如不在流程中执行）输出到 fallback
+type lineWriter struct {
+	prefix   string
+	fallback io.Writer
+
+	l      sync.Mutex
+	logger *NodeLogger
+	buf    bytes.Buffer
+}
+
+func (w *lineWriter) Write(p []byte) (n int, err error) {
+	w.l.Lock()
+	defer w.l.Unlock()
+	if w.logger == nil {
+		return w.fallback.Write(p)
+	}
+
+	w.buf.Write(p)
+	for {
+		line, err := w.buf.ReadString('\n')
+		if err != nil {
+			// 不完整的行留到下次写入
+			w.buf.Reset()
+			w.buf.WriteString(line)
+			break
+		}
+		w.logger.Log(w.prefix + line[:len(line)-1])
+	}
+	return len(p), nil
+}
+
+// setLogger 切换输出的目标，切换前输出没有换行结尾的内容
+func (w *lineWriter) setLogger(l *NodeLogger) {
+	w.l.Lock()
+	defer w.l.Unlock()
+	if w.buf.Len() != 0 {
+		w.logger.Log(w.prefix + w.buf.String())
+		w.buf.Reset()
+	}
+	w.logger = l
 }
@@ -1,7 +1,11 @@
 package writeflow
 
 import (
+	"context"
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -31,3 +35,79 @@ func TestNewGoScript(t *testing.T) {
 
 	assert.Equal(t, "hello bysir 18", r["msg"])
 }
+
+func TestGoScriptLogs(t *testing.T) {
+	cmd, err := NewGoScriptCMD(nil, "", `package main
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+func Exec(ctx context.Context, params map[string]interface{}) (rsp map[string]interface{}, err error) {
+	fmt.Println("hello", params["name"])
+	fmt.Print("a")
+	fmt.Print("b")
+	log.Println("oops")
+	return map[string]interface{}{}, nil
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			logger := NewNodeLogger(nil)
+			_, err := cmd.Exec(WithNodeLogger(context.Background(), logger), map[string]interface{}{"name": i})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			logs := logger.Logs()
+			if assert.Equal(t, 3, len(logs)) {
+				assert.Equal(t, fmt.Sprintf("hello %d", i), logs[0])
+				assert.True(t, strings.HasPrefix(logs[1], "[stderr] ") && strings.HasSuffix(logs[1], " oops"), logs[1])
+				assert.Equal(t, "ab", logs[2])
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestGoScriptInterpLimit(t *testing.T) {
+	cmd, err := NewGoScriptCMD(nil, "", `package main
+import (
+	"context"
+	"time"
+)
+
+func Exec(ctx context.Context, params map[string]interface{}) (rsp map[string]interface{}, err error) {
+	time.Sleep(10 * time.Millisecond)
+	return map[string]interface{}{"default": params["i"]}, nil
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3*maxGoScriptInterps; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rsp, err := cmd.Exec(context.Background(), map[string]interface{}{"i": i})
+			if assert.NoError(t, err) {
+				assert.Equal(t, i, rsp["default"])
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// 并发执行时最多编译 maxGoScriptInterps 个解释器
+	assert.LessOrEqual(t, cmd.created, maxGoScriptInterps)
+	assert.Equal(t, cmd.created, len(cmd.idle))
+}
@@ -2,25 +2,121 @@ package writeflow
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/dop251/goja"
+	"github.com/spf13/cast"
 	"github.com/zbysir/gojsx"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
 )
 
 type JavaScriptCMD struct {
-	src string
+	program          *goja.Program // 编译后的脚本，可以在多个 runtime 中共享
+	timeout          time.Duration
+	maxCallStackSize int
+	httpClient       *http.Client
 }
 
+// JavaScriptOption 配置脚本运行时的限制
+type JavaScriptOption func(c *JavaScriptCMD)
+
+// WithJsTimeout 设置单次执行的最长时间，超时后脚本会被中断。无论是否设置，ctx 取消时脚本都会被中断。
+func WithJsTimeout(d time.Duration) JavaScriptOption {
+	return func(c *JavaScriptCMD) {
+		c.timeout = d
+	}
+}
+
+// WithJsMaxCallStackSize 设置最大调用栈深度，防止无限递归，0 表示不限制
+func WithJsMaxCallStackSize(size int) JavaScriptOption {
+	return func(c *JavaScriptCMD) {
+		c.maxCallStackSize = size
+	}
+}
+
+// WithJsHttpClient 设置 fetch 使用的 http client
+func WithJsHttpClient(client *http.Client) JavaScriptOption {
+	return func(c *JavaScriptCMD) {
+		c.httpClient = client
+	}
+}
+
+// DefaultJsHttpClient 是 fetch 默认使用的 http client，不能访问内网地址，
+// 需要访问内网时替换为 NewJsHttpClient(true) 或者使用 WithJsHttpClient
+var DefaultJsHttpClient = NewJsHttpClient(false)
+
+// NewJsHttpClient 创建 fetch 使用的 http client。
+// allowPrivate 为 false 时拒绝连接 loopback、内网、link-local（如云服务的 metadata 地址 169.254.169.254）等地址，
+// 防止脚本访问部署机器所在的内网。检查在建立连接时进行，所以域名解析或者重定向到内网地址同样会被拒绝。
+func NewJsHttpClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if !allowPrivate {
+		dialer.Control = denyPrivateAddr
+		// 使用代理时连接的是代理的地址，无法检查真正访问的地址
+		transport.Proxy = nil
+	}
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{Timeout: 30 * time.Second, Transport: transport}
+}
+
+// sharedAddressSpace 是运营商级 NAT 的地址段，部分云服务的 metadata 地址也在这里（如 100.100.100.200）
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func denyPrivateAddr(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("invalid address '%s'", address)
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsMulticast() || sharedAddressSpace.Contains(ip) {
+		return fmt.Errorf("fetch private address '%s' is not allowed", ip)
+	}
+	return nil
+}
+
+// jsFetchMaxBody 是 fetch 最多读取的响应大小
+const jsFetchMaxBody = 10 << 20
+
 // src:
 // function exec(params){return params}
+//
+// 脚本中可以使用以下全局函数：
+//
+//	console.log(...args)  输出日志，日志会随节点状态一起报告，还有 info / warn / error / debug
+//	fetch(url, options)   发起 http 请求，返回 Promise，支持 method / headers / body，响应支持 text() / json()
+//	toJson(v, indent)     转为 json 字符串
+//	fromJson(s)           解析 json 字符串
+//
+// exec 可以是 async 函数。
+
+func NewJavaScriptCMD(src string, ops ...JavaScriptOption) (*JavaScriptCMD, error) {
+	program, err := goja.Compile("java_script_cmd", fmt.Sprintf("(%s)", src), false)
+	if err != nil {
+		return nil, fmt.Errorf("compile javascript error: %w", err)
+	}
 
-func NewJavaScriptCMD(src string) (*JavaScriptCMD, error) {
-	return &JavaScriptCMD{src: src}, nil
+	c := &JavaScriptCMD{program: program, httpClient: DefaultJsHttpClient}
+	for _, o := range ops {
+		o(c)
+	}
+	return c, nil
 }
 
-func newJsRuntime(src string) (*goja.Runtime, goja.Callable, error) {
+func newJsRuntime(program *goja.Program) (*goja.Runtime, goja.Callable, error) {
 	r := goja.New()
-	f, err := r.RunScript("java_script_cmd", fmt.Sprintf("(%s)", src))
+	f, err := r.RunProgram(program)
 	if err != nil {
 		return nil, nil, fmt.Errorf("run javascript error: %w", err)
 	}
@@ -33,15 +129,62 @@ func newJsRuntime(src string) (*goja.Runtime, goja.Callable, error) {
 }
 
 func (g *JavaScriptCMD) Exec(ctx context.Context, params Map) (rsp Map, err error) {
-	r, call, err := newJsRuntime(g.src)
+	if g.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.timeout)
+		defer cancel()
+	}
+
+	r, call, err := newJsRuntime(g.program)
+	if err != nil {
+		return Map{}, err
+	}
+	if g.maxCallStackSize > 0 {
+		r.SetMaxCallStackSize(g.maxCallStackSize)
+	}
+	err = g.setHelpers(ctx, r)
 	if err != nil {
 		return Map{}, err
 	}
 
+	// ctx 取消或者超时后中断脚本，防止死循环一直占用协程
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			r.Interrupt(ctx.Err())
+		case <-done:
+		}
+	}()
+
 	rspj, err := call(nil, r.ToValue(params))
 	if err != nil {
+		var interrupted *goja.InterruptedError
+		if errors.As(err, &interrupted) {
+			if e, ok := interrupted.Value().(error); ok {
+				return Map{}, fmt.Errorf("javascript interrupted: %w", e)
+			}
+		}
+		var overflow *goja.StackOverflowError
+		if errors.As(err, &overflow) {
+			return Map{}, fmt.Errorf("maximum call stack size (%d) exceeded", g.maxCallStackSize)
+		}
 		return Map{}, err
 	}
+
+	// async 的 exec 返回 Promise，脚本中没有真正的异步操作，返回时 Promise 已经完成
+	if p, ok := rspj.Export().(*goja.Promise); ok {
+		switch p.State() {
+		case goja.PromiseStateFulfilled:
+			rspj = p.Result()
+		case goja.PromiseStateRejected:
+			return Map{}, fmt.Errorf("javascript promise rejected: %v", p.Result())
+		default:
+			return Map{}, fmt.Errorf("javascript promise is still pending")
+		}
+	}
+
 	rsp = map[string]interface{}{}
 	err = r.ExportTo(rspj, &rsp)
 	if err != nil {
@@ -50,3 +193,164 @@ func (g *JavaScriptCMD) Exec(ctx context.Context, params Map) (rsp Map, err erro
 
 	return
 }
+
+func (g *JavaScriptCMD) setHelpers(ctx context.Context, r *goja.Runtime) error {
+	logger := GetNodeLogger(ctx)
+	console := r.NewObject()
+	for _, level := range []string{"log", "info", "warn", "error", "debug"} {
+		prefix := ""
+		if level != "log" && level != "info" {
+			prefix = "[" + level + "] "
+		}
+		err := console.Set(level, func(call goja.FunctionCall) goja.Value {
+			args := make([]string, len(call.Arguments))
+			for i, a := range call.Arguments {
+				args[i] = jsLogString(a)
+			}
+			logger.Log(prefix + strings.Join(args, " "))
+			return goja.Undefined()
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	helpers := map[string]interface{}{
+		"console": console,
+		"fetch": func(call goja.FunctionCall) goja.Value {
+			p, resolve, reject := r.NewPromise()
+			res, err := g.fetch(ctx, r, call.Argument(0).String(), call.Argument(1))
+			if err != nil {
+				reject(r.NewGoError(err))
+			} else {
+				resolve(res)
+			}
+			return r.ToValue(p)
+		},
+		"toJson": func(v interface{}, indent int) (string, error) {
+			var bs []byte
+			var err error
+			if indent > 0 {
+				bs, err = json.MarshalIndent(v, "", strings.Repeat(" ", indent))
+			} else {
+				bs, err = json.Marshal(v)
+			}
+			return string(bs), err
+		},
+		"fromJson": func(s string) (interface{}, error) {
+			var v interface{}
+			err := json.Unmarshal([]byte(s), &v)
+			return v, err
+		},
+	}
+	for k, v := range helpers {
+		err := r.Set(k, v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jsLogString 与浏览器的 console.log 类似，字符串原样输出，其他值转为 json
+func jsLogString(v goja.Value) string {
+	if goja.IsUndefined(v) {
+		return "undefined"
+	}
+	if s, ok := v.Export().(string); ok {
+		return s
+	}
+	bs, err := json.Marshal(v.Export())
+	if err != nil {
+		return v.String()
+	}
+	return string(bs)
+}
+
+// fetch 使用 httpClient 发起请求，options 支持 method / headers / body，body 不是字符串时会转为 json
+func (g *JavaScriptCMD) fetch(ctx context.Context, r *goja.Runtime, url string, options goja.Value) (*goja.Object, error) {
+	var opt struct {
+		Method  string            `json:"method"`
+		Headers map[string]string `json:"headers"`
+		Body    interface{}       `json:"body"`
+	}
+	if options != nil && !goja.IsUndefined(options) && !goja.IsNull(options) {
+		o := options.ToObject(r)
+		if v := o.Get("method"); v != nil {
+			opt.Method = cast.ToString(v.Export())
+		}
+		if v := o.Get("headers"); v != nil && !goja.IsUndefined(v) {
+			_ = r.ExportTo(v, &opt.Headers)
+		}
+		if v := o.Get("body"); v != nil {
+			opt.Body = v.Export()
+		}
+	}
+	if opt.Method == "" {
+		opt.Method = http.MethodGet
+	}
+
+	var body io.Reader
+	switch b := opt.Body.(type) {
+	case nil:
+	case string:
+		body = strings.NewReader(b)
+	default:
+		bs, err := json.Marshal(b)
+		if err != nil {
+			return nil, fmt.Errorf("fetch: marshal body error: %w", err)
+		}
+		body = strings.NewReader(string(bs))
+		if _, ok := opt.Headers["Content-Type"]; !ok {
+			if opt.Headers == nil {
+				opt.Headers = map[string]string{}
+			}
+			opt.Headers["Content-Type"] = "application/json"
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, strings.ToUpper(opt.Method), url, body)
+	if err != nil {
+		return nil, fmt.Errorf("fetch: %w", err)
+	}
+	for k, v := range opt.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch: %w", err)
+	}
+	defer resp.Body.Close()
+	bs, err := io.ReadAll(io.LimitReader(resp.Body, jsFetchMaxBody))
+	if err != nil {
+		return nil, fmt.Errorf("fetch: read body error: %w", err)
+	}
+
+	headers := map[string]interface{}{}
+	for k := range resp.Header {
+		headers[strings.ToLower(k)] = resp.Header.Get(k)
+	}
+
+	res := r.NewObject()
+	_ = res.Set("status", resp.StatusCode)
+	_ = res.Set("statusText", resp.Status)
+	_ = res.Set("ok", resp.StatusCode >= 200 && resp.StatusCode < 300)
+	_ = res.Set("url", url)
+	_ = res.Set("headers", headers)
+	_ = res.Set("text", func() goja.Value {
+		p, resolve, _ := r.NewPromise()
+		resolve(string(bs))
+		return r.ToValue(p)
+	})
+	_ = res.Set("json", func() goja.Value {
+		p, resolve, reject := r.NewPromise()
+		var v interface{}
+		if err := json.Unmarshal(bs, &v); err != nil {
+			reject(r.NewGoError(fmt.Errorf("fetch: parse json error: %w", err)))
+		} else {
+			resolve(v)
+		}
+		return r.ToValue(p)
+	})
+	return res, nil
+}
@@ -3,7 +3,11 @@ package writeflow
 import (
 	"context"
 	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestJavascript(t *testing.T) {
@@ -16,3 +20,61 @@ func TestJavascript(t *testing.T) {
 
 	assert.Equal(t, int64(1), r["default"])
 }
+
+func TestJavascriptInterrupt(t *testing.T) {
+	s, _ := NewJavaScriptCMD("function exec(params) {while(true){}}")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := s.Exec(ctx, map[string]interface{}{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	s, _ = NewJavaScriptCMD("function exec(params) {while(true){}}", WithJsTimeout(50*time.Millisecond))
+	_, err = s.Exec(context.Background(), map[string]interface{}{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	s, _ = NewJavaScriptCMD("function exec(params) {function f(){return f()}; return f()}", WithJsMaxCallStackSize(100))
+	_, err = s.Exec(context.Background(), map[string]interface{}{})
+	assert.ErrorContains(t, err, "call stack")
+}
+
+func TestJavascriptHelpers(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bs, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Method", r.Method)
+		_, _ = w.Write([]byte(`{"echo":` + string(bs) + `}`))
+	}))
+	defer srv.Close()
+
+	s, _ := NewJavaScriptCMD(`async function exec(params) {
+	console.log("url", params.url)
+	console.warn({a: 1})
+	const rsp = await fetch(params.url, {method: "post", body: {name: "bysir"}})
+	const data = await rsp.json()
+	return {default: data.echo.name, method: rsp.headers["x-method"], ok: rsp.ok, json: toJson(fromJson('{"b":2}'))}
+}`, WithJsHttpClient(NewJsHttpClient(true)))
+
+	var logs []string
+	logger := NewNodeLogger(func(l []string) { logs = l })
+	r, err := s.Exec(WithNodeLogger(context.Background(), logger), map[string]interface{}{"url": srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "bysir", r["default"])
+	assert.Equal(t, "POST", r["method"])
+	assert.Equal(t, true, r["ok"])
+	assert.Equal(t, `{"b":2}`, r["json"])
+	assert.Equal(t, []string{"url " + srv.URL, `[warn] {"a":1}`}, logs)
+	assert.Equal(t, logs, logger.Logs())
+
+	s, _ = NewJavaScriptCMD(`async function exec(params) { await fetch("http://127.0.0.1:1") }`)
+	_, err = s.Exec(context.Background(), map[string]interface{}{})
+	assert.ErrorContains(t, err, "rejected")
+
+	// 默认不能访问内网地址
+	s, _ = NewJavaScriptCMD(`async function exec(params) { await fetch(params.url) }`)
+	for _, u := range []string{srv.URL, "http://169.254.169.254/latest/meta-data", "http://10.0.0.1", "http://[::1]:1"} {
+		_, err = s.Exec(context.Background(), map[string]interface{}{"url": u})
+		assert.ErrorContains(t, err, "is not allowed", u)
+	}
+}
@@ -0,0 +1,141 @@
+package writeflow
+
+import (
+	"context"
+	"fmt"
+	"github.com/zbysir/writeflow/pkg/export"
+	"time"
+)
+
+// execPolicy 控制 cmd 的超时与重试，通过节点的保留输入配置：
+//   - _timeout: 单次执行的超时时间，支持 "10s" 或者数字（秒）；返回流时包含输出流的时间
+//   - _retry: 失败后的重试次数，默认不重试
+//   - _retry_backoff: 第一次重试前的等待时间，之后每次翻倍，格式同 _timeout
+type execPolicy struct {
+	Timeout time.Duration
+	Retry   int
+	Backoff time.Duration
+}
+
+var execPolicyKeys = []string{"_timeout", "_retry", "_retry_backoff"}
+
+// ExecPolicyInputParams 返回 execPolicy 的输入定义，同 export.ExecPolicyInputParams，用于内置组件
+func ExecPolicyInputParams() []NodeInputParam {
+	ps := make([]NodeInputParam, len(export.ExecPolicyInputParams))
+	for i, p := range export.ExecPolicyInputParams {
+		ps[i] = fromExportInputParam(p)
+	}
+	return ps
+}
+
+func (p *execPolicy) set(key string, value interface{}) (err error) {
+	switch key {
+	case "_timeout":
+		p.Timeout, err = ToDuration(value)
+	case "_retry":
+		p.Retry, err = toInt(value)
+		if p.Retry < 0 {
+			p.Retry = 0
+		}
+	case "_retry_backoff":
+		p.Backoff, err = ToDuration(value)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return nil
+}
+
+// exec 按照策略执行 cmd，每次尝试前都会调用 onAttempt，attempt 从 1 开始，lastErr 为上一次尝试的错误。
+func (p execPolicy) exec(ctx context.Context, cmder CMDer, params Map, onAttempt func(attempt int, lastErr error)) (rsp Map, attempt int, err error) {
+	backoff := p.Backoff
+	for attempt = 1; ; attempt++ {
+		if onAttempt != nil {
+			onAttempt(attempt, err)
+		}
+
+		rsp, err = p.execOnce(ctx, cmder, cloneMap(params))
+		if err == nil {
+			return rsp, attempt, nil
+		}
+
+		// 整个流程被取消或者重试次数用完
+		if ctx.Err() != nil || attempt > p.Retry {
+			return nil, attempt, err
+		}
+
+		if backoff > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, attempt, err
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+	}
+}
+
+func (p execPolicy) execOnce(ctx context.Context, cmder CMDer, params Map) (rsp Map, err error) {
+	if p.Timeout <= 0 {
+		return cmder.Exec(ctx, params)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	keepCtx := false
+	defer func() {
+		if !keepCtx {
+			cancel()
+		}
+	}()
+
+	// 不是所有的 cmd 都会处理 ctx，所以在协程中执行，超时后直接返回。
+	type result struct {
+		rsp Map
+		err error
+	}
+	c := make(chan result, 1)
+	go func() {
+		rsp, err := cmder.Exec(ctx, params)
+		c <- result{rsp: rsp, err: err}
+	}()
+
+	select {
+	case r := <-c:
+		// 返回流时 cmd 还在 ctx 中继续输出，流结束后才能取消
+		if r.err == nil && cancelAfterStreams(r.rsp, cancel) {
+			keepCtx = true
+		}
+		return r.rsp, r.err
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("exec timeout after %s: %w", p.Timeout, ctx.Err())
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// cancelAfterStreams 在 rsp 中所有的流都结束后调用 cancel，rsp 中没有流时返回 false
+func cancelAfterStreams(rsp Map, cancel context.CancelFunc) bool {
+	var streams []export.Stream
+	for _, v := range rsp {
+		if s, ok := v.(export.Stream); ok {
+			streams = append(streams, s)
+		}
+	}
+	if len(streams) == 0 {
+		return false
+	}
+
+	go func() {
+		defer cancel()
+		for _, s := range streams {
+			r := s.NewReader()
+			for {
+				if _, err := r.Read(); err != nil {
+					break
+				}
+			}
+		}
+	}()
+	return true
+}
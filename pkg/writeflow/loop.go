@@ -0,0 +1,208 @@
+package writeflow
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/cast"
+	"strings"
+	"sync"
+	"time"
+)
+
+// loopBody 返回循环节点 loopId 的循环体：直接或间接依赖了它的注入值（如 for.item）的节点。
+// 循环体在每次迭代中重新执行，其他节点与迭代无关，所有迭代共享它们的结果。
+func (d *Flow) loopBody(loopId string) map[string]bool {
+	body := map[string]bool{}
+	for changed := true; changed; {
+		changed = false
+		for id, n := range d.Nodes {
+			if body[id] || id == loopId {
+				continue
+			}
+		inputs:
+			for _, input := range n.Inputs {
+				for _, a := range input.Anchors {
+					if (a.NodeId == loopId && d.isInjectAnchor(a)) || body[a.NodeId] {
+						body[id] = true
+						changed = true
+						break inputs
+					}
+				}
+			}
+		}
+	}
+
+	return body
+}
+
+// 循环体输出的 _for_break 的值
+const (
+	forContinue = "continue" // 跳过当前项
+	forBreak    = "break"    // 跳过当前项并结束循环，也可以是 true
+)
+
+// getForBreak 读取循环体节点输出的 _for_break
+func (r *runner) getForBreak(itemInput NodeInput) string {
+	for _, a := range itemInput.Anchors {
+		r.l.RLock()
+		fu := r.futures[a.NodeId]
+		r.l.RUnlock()
+		if fu == nil {
+			// 不在循环体中的节点
+			continue
+		}
+		<-fu.done
+
+		v, ok := fu.rsp["_for_break"]
+		if !ok || v == nil {
+			continue
+		}
+		s := cast.ToString(v)
+		if s == forContinue || s == forBreak {
+			return s
+		}
+		if cast.ToBool(s) {
+			return forBreak
+		}
+	}
+
+	return ""
+}
+
+// execFor 对 items 中的每一项执行循环体，最多同时执行 concurrency 个迭代。
+// 每次迭代使用 fork 出的 runner，所以迭代间的 item 与执行结果互不干扰，返回的结果与 items 顺序一致。
+func (f *runner) execFor(ctx context.Context, nodeId string, items []interface{}, itemInput NodeInput, concurrency int, onNodeStatusChange func(result NodeStatusLog)) ([]interface{}, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	body := f.flowDef.loopBody(nodeId)
+	// 每次迭代都会重新执行循环体，需要从 for 节点重新记录路径
+	forCtx := withStack(ctx, Stack{}.Push(nodeId))
+
+	type iteration struct {
+		value interface{}
+		skip  bool
+		err   error
+	}
+	results := make([]iteration, len(items))
+	stopAt := len(items) // break 的位置，之后的迭代不再执行
+	failed := false
+
+	var l sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	for idx, item := range items {
+		sem <- struct{}{}
+		l.Lock()
+		stop := idx > stopAt || failed
+		if !stop && ctx.Err() != nil {
+			results[idx].err = ctx.Err()
+			failed = true
+			stop = true
+		}
+		l.Unlock()
+		if stop {
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+		go func(idx int, item interface{}) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			child := f.fork(body)
+			child.setInject(nodeId, "item", item)
+			child.setInject(nodeId, "index", idx)
+			v, err := child.calcInput(forCtx, nodeId, itemInput, false, onNodeStatusChange)
+
+			var brk string
+			if err == nil {
+				brk = child.getForBreak(itemInput)
+			}
+
+			l.Lock()
+			defer l.Unlock()
+			if err != nil {
+				results[idx].err = err
+				failed = true
+				return
+			}
+			results[idx].value = v
+			switch brk {
+			case forContinue:
+				results[idx].skip = true
+			case forBreak:
+				results[idx].skip = true
+				if idx < stopAt {
+					stopAt = idx
+				}
+			}
+		}(idx, item)
+	}
+	wg.Wait()
+
+	var rsps []interface{}
+	for idx, r := range results {
+		if idx > stopAt {
+			break
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		if r.skip {
+			continue
+		}
+		rsps = append(rsps, r.value)
+	}
+
+	return rsps, nil
+}
+
+// execWhile 重复执行循环体，直到 condition 不成立，至少执行一次。
+// 每次迭代注入 item（上一次迭代的结果，第一次为 init）与 index，condition 中可以使用 data（本次迭代的结果）与 index。
+// 超过 maxIterations 次时返回错误，防止死循环。
+func (f *runner) execWhile(ctx context.Context, nodeId string, init interface{}, bodyInput NodeInput, condition string, maxIterations int, start time.Time, onNodeStatusChange func(result NodeStatusLog)) (rsp Map, err error) {
+	if maxIterations <= 0 {
+		return nil, fmt.Errorf("max_iterations is required")
+	}
+	if strings.TrimSpace(condition) == "" {
+		return nil, fmt.Errorf("condition is required")
+	}
+
+	body := f.flowDef.loopBody(nodeId)
+	whileCtx := withStack(ctx, Stack{}.Push(nodeId))
+
+	item := init
+	for index := 0; index < maxIterations; index++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		child := f.fork(body)
+		child.setInject(nodeId, "item", item)
+		child.setInject(nodeId, "index", index)
+		item, err = child.calcInput(whileCtx, nodeId, bodyInput, false, onNodeStatusChange)
+		if err != nil {
+			return nil, err
+		}
+
+		// 每次迭代都报告 running 状态
+		if onNodeStatusChange != nil {
+			onNodeStatusChange(NewNodeStatusLog(nodeId, StatusRunning, "", Map{"default": item, "index": index}, start, time.Time{}))
+		}
+
+		v, err := LookInterface(map[string]interface{}{"data": item, "index": index}, condition)
+		if err != nil {
+			return nil, fmt.Errorf("exec condition %s error: %w", condition, err)
+		}
+		if !cast.ToBool(cast.ToString(v)) {
+			return Map{"default": item, "iterations": index + 1}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("condition '%s' still holds after %d iterations", condition, maxIterations)
+}
@@ -109,6 +109,7 @@ const (
 	StatusFailed      NodeStatus = "failed"
 	StatusPending     NodeStatus = "pending"
 	StatusUnreachable NodeStatus = "unreachable" // 被 if 分支忽略
+	StatusCancelled   NodeStatus = "cancelled"   // 运行被取消
 )
 
 // NodeStatusLog save node run result
@@ -122,6 +123,10 @@ type NodeStatusLog struct {
 	RunAt     time.Time   `json:"run_at"`
 	EndAt     time.Time   `json:"end_at,omitempty"`
 	Spend     string      `json:"spend,omitempty"`
+	Attempt   int         `json:"attempt,omitempty"`   // 第几次尝试执行，配置了 _retry 时才有意义
+	Reason    string      `json:"reason,omitempty"`    // 节点为 unreachable 的原因，如 switch 选择了其他分支
+	Logs      []string    `json:"logs,omitempty"`      // cmd 运行时输出的日志，如 js 的 console.log
+	Streaming []string    `json:"streaming,omitempty"` // 输出节点中流式输出的 key，result 中是这些 key 已经读取到的内容
 }
 
 func NewNodeStatusLog(nodeId string, status NodeStatus, error string, result Map, runAt time.Time, endAt time.Time) NodeStatusLog {
@@ -140,7 +145,13 @@ func NewNodeStatusLog(nodeId string, status NodeStatus, error string, result Map
 }
 
 func (r *NodeStatusLog) Json() ([]byte, error) {
-	// 过滤私密信息
+	r.FillResult()
+
+	return json.Marshal(r)
+}
+
+// FillResult 使用过滤了私密信息的 ResultRaw 填充 Result，Result 才会被序列化
+func (r *NodeStatusLog) FillResult() {
 	rr := map[string]interface{}{}
 	for k, v := range r.ResultRaw {
 		if d, ok := v.(interface{ Display() string }); ok {
@@ -150,6 +161,4 @@ func (r *NodeStatusLog) Json() ([]byte, error) {
 		}
 	}
 	r.Result = rr
-
-	return json.Marshal(r)
 }
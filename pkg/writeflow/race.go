@@ -0,0 +1,124 @@
+package writeflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// skipCause 作为 ctx 的取消原因，表示节点是被主动放弃的（如 _race 中落后的节点），而不是运行被取消。
+// 这样的节点报告 unreachable 状态，结果也不会被缓存。
+type skipCause struct {
+	reason string
+}
+
+func (s *skipCause) Error() string {
+	return s.reason
+}
+
+// isSkipped 返回 err 是否是因为节点被主动放弃而取消的，以及放弃的原因
+func isSkipped(ctx context.Context, err error) (reason string, ok bool) {
+	if !errors.Is(err, context.Canceled) {
+		return "", false
+	}
+	var s *skipCause
+	if errors.As(context.Cause(ctx), &s) {
+		return s.reason, true
+	}
+	return "", false
+}
+
+// candidates 将输入拆分为候选值，每个 anchor 单独作为一个候选，顺序与输入一致
+func candidates(inputs NodeInputs) (cs NodeInputs) {
+	for _, input := range inputs {
+		if input.Type != NodeInputAnchor {
+			cs = append(cs, input)
+			continue
+		}
+		for _, a := range input.Anchors {
+			c := input
+			c.List = false
+			c.Anchors = []NodeAnchorTarget{a}
+			cs = append(cs, c)
+		}
+	}
+	return cs
+}
+
+func candidateName(c NodeInput) string {
+	if len(c.Anchors) != 0 {
+		return c.Anchors[0].NodeId
+	}
+	return c.Key
+}
+
+// execRace 同时计算所有候选值，第一个成功的结果胜出，其他候选通过 ctx 取消。
+// 每个候选使用单独的 ctx，胜出的候选返回流时不会被取消，流结束后才释放。
+// 所有候选都失败时返回错误。
+func (f *runner) execRace(ctx context.Context, nodeId string, inputs NodeInputs, nocache bool, onNodeStatusChange func(result NodeStatusLog)) (Map, error) {
+	cs := candidates(inputs)
+	if len(cs) == 0 {
+		return Map{"default": nil, "winner": ""}, nil
+	}
+
+	cancels := make([]context.CancelCauseFunc, len(cs))
+	type result struct {
+		index int
+		value interface{}
+		err   error
+	}
+	results := make(chan result, len(cs))
+	// 落后的候选在 race 返回后才会结束，运行需要等待它们报告状态
+	root := f.root()
+	for i, c := range cs {
+		var candidateCtx context.Context
+		candidateCtx, cancels[i] = context.WithCancelCause(ctx)
+		root.background.Add(1)
+		go func(i int, c NodeInput) {
+			defer root.background.Done()
+			v, err := f.calcInput(candidateCtx, nodeId, c, nocache, onNodeStatusChange)
+			results <- result{index: i, value: v, err: err}
+		}(i, c)
+	}
+
+	var errs []error
+	for range cs {
+		r := <-results
+		if r.err == nil {
+			winner := candidateName(cs[r.index])
+			for i, cancel := range cancels {
+				if i != r.index {
+					cancel(&skipCause{reason: fmt.Sprintf("race '%s' was won by '%s'", nodeId, winner)})
+				}
+			}
+			cancelWinner := func() { cancels[r.index](nil) }
+			if !cancelAfterStreams(Map{"default": r.value}, cancelWinner) {
+				cancelWinner()
+			}
+			return Map{"default": r.value, "winner": winner}, nil
+		}
+		errs = append(errs, r.err)
+	}
+	for _, cancel := range cancels {
+		cancel(nil)
+	}
+
+	return nil, fmt.Errorf("all candidates failed: %w", errors.Join(errs...))
+}
+
+// execCoalesce 按顺序计算候选值，返回第一个不为 nil 的值，之后的候选不会执行
+func (f *runner) execCoalesce(ctx context.Context, nodeId string, inputs NodeInputs, nocache bool, onNodeStatusChange func(result NodeStatusLog)) (Map, error) {
+	cs := candidates(inputs)
+	for i, c := range cs {
+		v, err := f.calcInput(ctx, nodeId, c, nocache, onNodeStatusChange)
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			f.skipInputs(cs[i+1:], fmt.Sprintf("coalesce '%s' got a value from '%s'", nodeId, candidateName(c)))
+			return Map{"default": v}, nil
+		}
+	}
+
+	return Map{"default": nil}, nil
+}
@@ -0,0 +1,218 @@
+package writeflow
+
+import (
+	"context"
+	"fmt"
+	"github.com/zbysir/writeflow/pkg/export"
+	"io"
+	"strings"
+	"sync"
+)
+
+// StreamChunk 是流式 cmd 输出的一段文本，Err 不为 nil 时流以错误结束
+type StreamChunk struct {
+	Text string
+	Err  error
+}
+
+// StreamCMDer 是可以流式处理输入的 cmd。
+// 当输入中有流（export.Stream）时，runner 不会等待流读取完成，而是直接调用 ExecStream，
+// cmd 边读边输出，输出作为一个新的流放在 default 中，下游的节点可以继续流式处理。
+// cmd 需要在输出完成或者 ctx 取消后关闭 channel。没有流输入时仍然调用 Exec。
+// 流式执行同样使用 _timeout 与 _retry：超时包含输出流的时间，只有 ExecStream 返回错误时才会重试，流中的错误不会重试。
+type StreamCMDer interface {
+	CMDer
+	ExecStream(ctx context.Context, params Map) (<-chan StreamChunk, error)
+}
+
+type streamFun struct {
+	ExecFun
+	stream func(ctx context.Context, params Map) (<-chan StreamChunk, error)
+}
+
+func (s *streamFun) ExecStream(ctx context.Context, params Map) (<-chan StreamChunk, error) {
+	return s.stream(ctx, params)
+}
+
+// NewStreamFun 使用函数创建 StreamCMDer
+func NewStreamFun(exec ExecFun, stream func(ctx context.Context, params Map) (<-chan StreamChunk, error)) StreamCMDer {
+	return &streamFun{ExecFun: exec, stream: stream}
+}
+
+// HasStream 返回 params 中是否有流
+func HasStream(params Map) bool {
+	for _, v := range params {
+		if _, ok := v.(export.Stream); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadStream 读取流中的所有内容，不是流则原样返回
+func ReadStream(v interface{}) (interface{}, error) {
+	s, ok := v.(export.Stream)
+	if !ok {
+		return v, nil
+	}
+	ts, err := s.NewReader().ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	return strings.Join(ts, ""), nil
+}
+
+// ForStream 依次读取流中的每一段文本，v 不是流时作为一段文本处理
+func ForStream(ctx context.Context, v interface{}, f func(text string) error) error {
+	s, ok := v.(export.Stream)
+	if !ok {
+		return f(fmt.Sprintf("%v", v))
+	}
+	r := s.NewReader()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		t, err := r.Read()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err := f(t); err != nil {
+			return err
+		}
+	}
+}
+
+// Stream 是可以被多个节点重复读取的流，每个 reader 都从头开始读取
+type Stream struct {
+	l      sync.Mutex
+	data   []string
+	err    error
+	closed bool
+	notify chan struct{} // 有新数据或者关闭时关闭并替换
+}
+
+var _ export.Stream = (*Stream)(nil)
+
+func NewStream() *Stream {
+	return &Stream{notify: make(chan struct{})}
+}
+
+// Display 返回空，不能被序列化
+func (s *Stream) Display() string {
+	return ""
+}
+
+func (s *Stream) Append(t string) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.closed {
+		return
+	}
+	s.data = append(s.data, t)
+	close(s.notify)
+	s.notify = make(chan struct{})
+}
+
+// Close 结束流，err 不为 nil 时 reader 会在读取完已有数据后得到这个错误
+func (s *Stream) Close(err error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.err = err
+	close(s.notify)
+}
+
+func (s *Stream) NewReader() export.Reader {
+	return &streamReader{s: s}
+}
+
+type streamReader struct {
+	s   *Stream
+	idx int
+}
+
+func (r *streamReader) Read() (string, error) {
+	for {
+		r.s.l.Lock()
+		if r.idx < len(r.s.data) {
+			t := r.s.data[r.idx]
+			r.idx++
+			r.s.l.Unlock()
+			return t, nil
+		}
+		if r.s.closed {
+			err := r.s.err
+			r.s.l.Unlock()
+			if err == nil {
+				err = io.EOF
+			}
+			return "", err
+		}
+		notify := r.s.notify
+		r.s.l.Unlock()
+		<-notify
+	}
+}
+
+func (r *streamReader) ReadAll() ([]string, error) {
+	var ts []string
+	for {
+		t, err := r.Read()
+		if err != nil {
+			if err == io.EOF {
+				return ts, nil
+			}
+			return ts, err
+		}
+		ts = append(ts, t)
+	}
+}
+
+// streamCmd 将流式执行包装为 CMDer，和普通 cmd 一样使用 execPolicy 与 HandlePanicCmd
+type streamCmd struct {
+	s StreamCMDer
+}
+
+func (c streamCmd) Exec(ctx context.Context, params Map) (Map, error) {
+	return execStream(ctx, c.s, params)
+}
+
+// execStream 流式执行 cmd，将输出的 channel 转为 Stream
+func execStream(ctx context.Context, cmder StreamCMDer, params Map) (rsp Map, err error) {
+	ch, err := cmder.ExecStream(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	s := NewStream()
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				s.Close(ctx.Err())
+				return
+			case c, ok := <-ch:
+				if !ok {
+					s.Close(nil)
+					return
+				}
+				if c.Err != nil {
+					s.Close(c.Err)
+					return
+				}
+				if c.Text != "" {
+					s.Append(c.Text)
+				}
+			}
+		}
+	}()
+
+	return Map{"default": s}, nil
+}
@@ -0,0 +1,157 @@
+package writeflow
+
+import (
+	"context"
+	"fmt"
+	"github.com/samber/lo"
+	"sort"
+	"sync"
+)
+
+// Executor 决定如何调度流程中的节点
+type Executor = string
+
+const (
+	// ExecutorPull 从根节点开始递归拉取依赖，parallel 控制的是协程数（默认）
+	ExecutorPull Executor = "pull"
+	// ExecutorTopo 按拓扑序调度就绪的节点，parallel 控制同时调度的节点数。
+	// 懒输入（如 _race 的候选、_for 的循环体）由节点执行时拉取，不受 parallel 限制。
+	ExecutorTopo Executor = "topo"
+)
+
+type ExecOption func(*execOption)
+
+type execOption struct {
+	executor Executor
+}
+
+func WithExecutor(executor Executor) ExecOption {
+	return func(o *execOption) {
+		o.executor = executor
+	}
+}
+
+func newExecOption(ops []ExecOption) (execOption, error) {
+	o := execOption{executor: ExecutorPull}
+	for _, op := range ops {
+		op(&o)
+	}
+	switch o.executor {
+	case "":
+		o.executor = ExecutorPull
+	case ExecutorPull, ExecutorTopo:
+	default:
+		return o, fmt.Errorf("unknown executor '%s'", o.executor)
+	}
+	return o, nil
+}
+
+// isLazyInput 返回输入是否为懒值，懒值由节点自己在执行时决定是否计算（如 switch 的分支），
+// 拓扑调度时不会提前执行它们依赖的节点。
+func (n Node) isLazyInput(key string) bool {
+	if key == "_enable" || lo.Contains(execPolicyKeys, key) {
+		return false
+	}
+	// _enable 为 false 时不会计算其他输入
+	if _, _, ok := n.Inputs.PopKey("_enable"); ok {
+		return true
+	}
+
+	switch n.Cmd {
+	case "_switch":
+		return key != "data"
+	case "_for":
+		return key == "item"
+	case "_while":
+		return key == "body"
+	case "_try":
+		return key == "fallback"
+	case "_race", "_coalesce":
+		return true
+	}
+	return false
+}
+
+// execTopo 按拓扑序执行 targets 及其依赖，同时调度的节点不超过 parallel 个。
+// 节点通过 execNodeOnce 执行，依赖都已经有结果，懒输入则由节点在执行时拉取，
+// 拉取时运行的节点不占用这里的协程，所以有懒输入时同时执行的节点可能超过 parallel 个。
+func (r *runner) execTopo(ctx context.Context, targets []string, parallel int, onNodeStatusChange func(result NodeStatusLog)) {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	deps := map[string][]string{} // nodeId -> 需要提前执行的依赖
+	var collect func(id string)
+	collect = func(id string) {
+		if _, ok := deps[id]; ok {
+			return
+		}
+		node, ok := r.flowDef.Nodes[id]
+		if !ok {
+			return
+		}
+		deps[id] = nil
+		var ds []string
+		for _, input := range node.Inputs {
+			if input.Type != NodeInputAnchor || node.isLazyInput(input.Key) {
+				continue
+			}
+			for _, a := range input.Anchors {
+				if _, ok := r.flowDef.Nodes[a.NodeId]; !ok || r.flowDef.isInjectAnchor(a) {
+					continue
+				}
+				ds = append(ds, a.NodeId)
+				collect(a.NodeId)
+			}
+		}
+		deps[id] = lo.Uniq(ds)
+	}
+	for _, id := range targets {
+		collect(id)
+	}
+
+	ids := lo.Keys(deps)
+	sort.Strings(ids)
+
+	pending := map[string]int{}         // nodeId -> 还未完成的依赖数
+	dependents := map[string][]string{} // nodeId -> 依赖它的节点
+	for _, id := range ids {
+		pending[id] = len(deps[id])
+		for _, d := range deps[id] {
+			dependents[d] = append(dependents[d], id)
+		}
+	}
+
+	ready := make(chan string, len(ids))
+	done := make(chan string, len(ids))
+	for _, id := range ids {
+		if pending[id] == 0 {
+			ready <- id
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range ready {
+				// 错误已经记录在 future 中，依赖它的节点执行时会得到同样的错误
+				_, _ = r.execNodeOnce(ctx, id, onNodeStatusChange)
+				done <- id
+			}
+		}()
+	}
+
+	for range ids {
+		id := <-done
+		for _, d := range dependents[id] {
+			pending[d]--
+			if pending[d] == 0 {
+				ready <- d
+			}
+		}
+	}
+	close(ready)
+	wg.Wait()
+}
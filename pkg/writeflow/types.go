@@ -0,0 +1,375 @@
+package writeflow
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/cast"
+	"github.com/zbysir/writeflow/pkg/export"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// PortKind 是端口（组件的输入与输出）数据类型的种类
+type PortKind string
+
+const (
+	PortAny    PortKind = "any"
+	PortString PortKind = "string"
+	PortNumber PortKind = "number"
+	PortInt    PortKind = "int"
+	PortBool   PortKind = "bool"
+	PortObject PortKind = "object" // map 或者结构体
+	PortList   PortKind = "list"   // 数组，元素类型见 Elem
+	PortHandle PortKind = "handle" // 不透明的对象，如 llm.llm、llm.vector_store，只能连接到同名的类型
+)
+
+// PortType 是端口的数据类型，由组件定义中的 type 字符串解析而来，见 ParsePortType
+type PortType struct {
+	Kind PortKind
+	Elem *PortType // list 的元素类型
+	Name string    // handle 的名字
+}
+
+// portTypeAlias 是组件定义中出现过的类型的别名
+var portTypeAlias = map[string]PortKind{
+	"":        PortAny,
+	"any":     PortAny,
+	"json":    PortAny,
+	"string":  PortString,
+	"number":  PortNumber,
+	"float":   PortNumber,
+	"int":     PortInt,
+	"bool":    PortBool,
+	"object":  PortObject,
+	"map":     PortObject,
+	"list":    PortList,
+	"array":   PortList,
+	"boolean": PortBool,
+}
+
+// ParsePortType 解析类型字符串，支持：
+//   - 基本类型：any / string / number / int / bool / object
+//   - 数组：[]T 或 list[T]，list 等同于 []any
+//   - 其他的名字（如 llm.llm）作为不透明的 handle 类型
+func ParsePortType(s string) PortType {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "[]") {
+		elem := ParsePortType(s[2:])
+		return PortType{Kind: PortList, Elem: &elem}
+	}
+	if strings.HasPrefix(s, "list[") && strings.HasSuffix(s, "]") {
+		elem := ParsePortType(s[5 : len(s)-1])
+		return PortType{Kind: PortList, Elem: &elem}
+	}
+
+	if k, ok := portTypeAlias[strings.ToLower(s)]; ok {
+		if k == PortList {
+			return PortType{Kind: PortList, Elem: &PortType{Kind: PortAny}}
+		}
+		return PortType{Kind: k}
+	}
+	return PortType{Kind: PortHandle, Name: s}
+}
+
+func (t PortType) String() string {
+	switch t.Kind {
+	case PortList:
+		return "[]" + t.elem().String()
+	case PortHandle:
+		return t.Name
+	default:
+		return string(t.Kind)
+	}
+}
+
+func (t PortType) elem() PortType {
+	if t.Elem == nil {
+		return PortType{Kind: PortAny}
+	}
+	return *t.Elem
+}
+
+func (t PortType) primitive() bool {
+	switch t.Kind {
+	case PortString, PortNumber, PortInt, PortBool:
+		return true
+	}
+	return false
+}
+
+// AssignableTo 返回类型为 t 的输出是否可以连接到类型为 in 的输入：
+//   - any 可以连接任何类型
+//   - 基本类型之间可以相互连接，运行时转换，如 number 转为 string，"1" 转为 int（无法转换时原样传给 cmd）
+//   - 数组的元素类型需要可以连接
+//   - handle 类型只能连接到同名的 handle
+func (t PortType) AssignableTo(in PortType) bool {
+	if t.Kind == PortAny || in.Kind == PortAny {
+		return true
+	}
+	if t.primitive() && in.primitive() {
+		return true
+	}
+	if t.Kind != in.Kind {
+		return false
+	}
+
+	switch t.Kind {
+	case PortList:
+		return t.elem().AssignableTo(in.elem())
+	case PortHandle:
+		return t.Name == in.Name
+	}
+	return true
+}
+
+// Coerce 将值转为类型 t，无法转换时返回错误，用于检查外部传入的值（如应用的参数）。
+// nil 与已经是对应类型的值原样返回（如 int64 对于 number），流（export.Stream）只能作为 string 或 any。
+func (t PortType) Coerce(v interface{}) (interface{}, error) {
+	if v == nil || t.Kind == PortAny {
+		return v, nil
+	}
+	if _, ok := v.(export.Stream); ok {
+		if t.Kind == PortString {
+			return v, nil
+		}
+		return nil, fmt.Errorf("cannot use stream as %s", t)
+	}
+
+	rv := reflect.ValueOf(v)
+	kind := rv.Kind()
+	// 未填写的字面量是空字符串，保持原样
+	if kind == reflect.String && strings.TrimSpace(rv.String()) == "" {
+		return v, nil
+	}
+	isNumber := kind >= reflect.Int && kind <= reflect.Float64
+	mismatch := func() error {
+		return fmt.Errorf("cannot use %T as %s", v, t)
+	}
+
+	switch t.Kind {
+	case PortString:
+		switch {
+		case kind == reflect.String:
+			return v, nil
+		case isNumber || kind == reflect.Bool:
+			return cast.ToString(v), nil
+		}
+	case PortNumber:
+		switch {
+		case isNumber:
+			return v, nil
+		case kind == reflect.String:
+			f, err := strconv.ParseFloat(strings.TrimSpace(rv.String()), 64)
+			if err != nil {
+				return nil, fmt.Errorf("cannot convert '%s' to number", rv.String())
+			}
+			return f, nil
+		}
+	case PortInt:
+		switch {
+		case kind >= reflect.Int && kind <= reflect.Uint64:
+			return v, nil
+		case kind == reflect.Float32 || kind == reflect.Float64:
+			f := rv.Float()
+			if f != float64(int(f)) {
+				return nil, fmt.Errorf("cannot convert %v to int", f)
+			}
+			return int(f), nil
+		case kind == reflect.String:
+			i, err := strconv.Atoi(strings.TrimSpace(rv.String()))
+			if err != nil {
+				return nil, fmt.Errorf("cannot convert '%s' to int", rv.String())
+			}
+			return i, nil
+		}
+	case PortBool:
+		switch kind {
+		case reflect.Bool:
+			return v, nil
+		case reflect.String:
+			b, err := strconv.ParseBool(strings.TrimSpace(rv.String()))
+			if err != nil {
+				return nil, fmt.Errorf("cannot convert '%s' to bool", rv.String())
+			}
+			return b, nil
+		}
+	case PortObject:
+		switch {
+		case kind == reflect.Map || kind == reflect.Struct:
+			return v, nil
+		case kind == reflect.Pointer && rv.Elem().Kind() == reflect.Struct:
+			return v, nil
+		case kind == reflect.String:
+			var m map[string]interface{}
+			if err := json.Unmarshal([]byte(rv.String()), &m); err != nil {
+				return nil, fmt.Errorf("cannot convert string to object: %w", err)
+			}
+			return m, nil
+		}
+	case PortList:
+		switch kind {
+		case reflect.Slice, reflect.Array:
+			return t.coerceList(rv)
+		case reflect.String:
+			var l []interface{}
+			if err := json.Unmarshal([]byte(rv.String()), &l); err != nil {
+				return nil, fmt.Errorf("cannot convert string to list: %w", err)
+			}
+			return t.coerceList(reflect.ValueOf(l))
+		}
+	case PortHandle:
+		// handle 的具体类型由 cmd 决定，这里只排除明显错误的连接，如将 string 连接到 llm
+		if kind != reflect.String && !isNumber && kind != reflect.Bool {
+			return v, nil
+		}
+	}
+
+	return nil, mismatch()
+}
+
+// coerceList 转换数组的每个元素，有元素被转换时返回新的 []interface{}，否则原样返回
+func (t PortType) coerceList(rv reflect.Value) (interface{}, error) {
+	elem := t.elem()
+	if elem.Kind == PortAny {
+		return rv.Interface(), nil
+	}
+
+	changed := false
+	l := make([]interface{}, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		v := rv.Index(i).Interface()
+		c, err := elem.Coerce(v)
+		if err != nil {
+			return nil, fmt.Errorf("item %d: %w", i, err)
+		}
+		if !reflect.DeepEqual(c, v) {
+			changed = true
+		}
+		l[i] = c
+	}
+	if !changed {
+		return rv.Interface(), nil
+	}
+	return l, nil
+}
+
+// PortTypeError 是输入的类型错误，在构建与检查流程时（连线的类型不兼容）返回
+type PortTypeError struct {
+	InputKey string
+	Err      error
+}
+
+func (e *PortTypeError) Error() string {
+	return fmt.Sprintf("input '%s': %v", e.InputKey, e.Err)
+}
+
+func (e *PortTypeError) Unwrap() error {
+	return e.Err
+}
+
+// convert 是执行 cmd 之前对输入的转换，只在基本类型之间做无损的转换，如 "1" 转为 int、1.5 转为 "1.5"。
+// 其他的值（如对象、流、无法解析的字符串）原样交给 cmd，和没有声明类型时一样，
+// 连线的类型问题由 CheckTypes 与 Validate 在运行之前检查。
+func (t PortType) convert(v interface{}) interface{} {
+	switch {
+	case t.primitive():
+		if !isPrimitiveValue(v) {
+			return v
+		}
+		c, err := t.Coerce(v)
+		if err != nil {
+			return v
+		}
+		return c
+	case t.Kind == PortList && t.elem().primitive():
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			return v
+		}
+		elem := t.elem()
+		changed := false
+		l := make([]interface{}, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			l[i] = elem.convert(rv.Index(i).Interface())
+			if !reflect.DeepEqual(l[i], rv.Index(i).Interface()) {
+				changed = true
+			}
+		}
+		if changed {
+			return l
+		}
+	}
+	return v
+}
+
+func isPrimitiveValue(v interface{}) bool {
+	if v == nil {
+		return false
+	}
+	switch reflect.TypeOf(v).Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// coerceInputs 将 cmd 的输入转为声明的类型，支持连接多个输入（List）时对每个值分别转换，见 PortType.convert
+func coerceInputs(inputs NodeInputs, values Map) {
+	for _, input := range inputs {
+		if input.DataType == "" {
+			continue
+		}
+		v, ok := values[input.Key]
+		if !ok {
+			continue
+		}
+
+		t := ParsePortType(input.DataType)
+		if input.Type == NodeInputAnchor && input.List {
+			t = PortType{Kind: PortList, Elem: &t}
+		}
+		values[input.Key] = t.convert(v)
+	}
+}
+
+// typeErrors 检查节点 id 的连线两端的类型是否兼容，输出未声明类型（如动态输出）的连线不检查
+func (d *Flow) typeErrors(id string) (errs []*PortTypeError) {
+	for _, input := range d.Nodes[id].Inputs {
+		if input.Type != NodeInputAnchor || input.DataType == "" {
+			continue
+		}
+		in := ParsePortType(input.DataType)
+		for _, a := range input.Anchors {
+			target, ok := d.Nodes[a.NodeId]
+			if !ok || d.isInjectAnchor(a) {
+				continue
+			}
+			out, ok := target.Outputs.Get(a.OutputKey)
+			if !ok || out.Type == "" {
+				continue
+			}
+			ot := ParsePortType(out.Type)
+			if !ot.AssignableTo(in) {
+				errs = append(errs, &PortTypeError{
+					InputKey: input.Key,
+					Err:      fmt.Errorf("cannot connect output '%s' of node '%s' (%s) to %s", a.OutputKey, a.NodeId, ot, in),
+				})
+			}
+		}
+	}
+	return errs
+}
+
+// CheckTypes 检查流程中所有连线的类型，返回第一个类型错误（*ExecNodeError，Cause 为 *PortTypeError）
+func (d *Flow) CheckTypes() error {
+	for _, id := range d.sortedNodeIds() {
+		if errs := d.typeErrors(id); len(errs) != 0 {
+			return NewExecNodeError(errs[0], id)
+		}
+	}
+	return nil
+}
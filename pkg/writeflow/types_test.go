@@ -0,0 +1,139 @@
+package writeflow
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestPortType(t *testing.T) {
+	assert.Equal(t, "[]llm.fragment", ParsePortType("[]llm.fragment").String())
+	assert.Equal(t, "[]string", ParsePortType("list[string]").String())
+	assert.Equal(t, "[]any", ParsePortType("list").String())
+	assert.Equal(t, "any", ParsePortType("").String())
+
+	cases := []struct {
+		out, in string
+		ok      bool
+	}{
+		{"string", "any", true},
+		{"llm.llm", "any", true},
+		{"int", "string", true},
+		{"string", "number", true},
+		{"llm.llm", "string", false},
+		{"llm.llm", "llm.llm", true},
+		{"llm.llm", "llm.vector_store", false},
+		{"[]string", "[]number", true},
+		{"[]llm.fragment", "string", false},
+		{"[]llm.fragment", "list", true},
+		{"object", "string", false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.ok, ParsePortType(c.out).AssignableTo(ParsePortType(c.in)), "%s -> %s", c.out, c.in)
+	}
+
+	type client struct{}
+	coerce := []struct {
+		typ   string
+		in    interface{}
+		out   interface{}
+		error string
+	}{
+		{typ: "string", in: 1.5, out: "1.5"},
+		{typ: "string", in: PasswordString("x"), out: PasswordString("x")},
+		{typ: "string", in: &client{}, error: "cannot use *writeflow.client as string"},
+		{typ: "string", in: map[string]interface{}{"a": 1}, error: "cannot use map[string]interface {} as string"},
+		{typ: "number", in: " 2.5 ", out: 2.5},
+		{typ: "number", in: int64(1), out: int64(1)},
+		{typ: "number", in: "", out: ""},
+		{typ: "int", in: 3.0, out: 3},
+		{typ: "int", in: "a", error: "cannot convert 'a' to int"},
+		{typ: "bool", in: "true", out: true},
+		{typ: "object", in: `{"a":1}`, out: map[string]interface{}{"a": float64(1)}},
+		{typ: "[]int", in: []interface{}{"1", 2}, out: []interface{}{1, 2}},
+		{typ: "[]string", in: []string{"a"}, out: []string{"a"}},
+		{typ: "llm.llm", in: "gpt", error: "cannot use string as llm.llm"},
+		{typ: "llm.llm", in: &client{}, out: &client{}},
+	}
+	for _, c := range coerce {
+		v, err := ParsePortType(c.typ).Coerce(c.in)
+		if c.error != "" {
+			assert.EqualError(t, err, c.error, c.typ)
+			continue
+		}
+		assert.NoError(t, err, c.typ)
+		assert.Equal(t, c.out, v, c.typ)
+	}
+}
+
+func TestCheckTypes(t *testing.T) {
+	f := Flow{
+		Nodes: map[string]Node{
+			"llm": {Id: "llm", Cmd: "llm", Outputs: NodeOutputs{{Key: "default", Type: "llm.llm"}}},
+			"template": {Id: "template", Cmd: "template", Inputs: []NodeInput{
+				{Key: "default", Type: NodeInputAnchor, DataType: "string", Anchors: []NodeAnchorTarget{{NodeId: "llm", OutputKey: "default"}}},
+				{Key: "n", Type: NodeInputAnchor, DataType: "number", Anchors: []NodeAnchorTarget{{NodeId: "llm", OutputKey: "default"}}},
+			}},
+		},
+	}
+
+	// Validate 返回所有的类型问题
+	ps := f.Validate(map[string]CMDer{"llm": NewFun(nil), "template": NewFun(nil)})
+	assert.Equal(t, []FlowProblem{
+		{Type: ProblemTypeMismatch, NodeId: "template", InputKey: "default", Message: "input 'default': cannot connect output 'default' of node 'llm' (llm.llm) to string"},
+		{Type: ProblemTypeMismatch, NodeId: "template", InputKey: "n", Message: "input 'n': cannot connect output 'default' of node 'llm' (llm.llm) to number"},
+	}, ps)
+
+	err := f.CheckTypes()
+	var te *PortTypeError
+	assert.True(t, errors.As(err, &te))
+	p, ok := ProblemFromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, ps[0], p)
+}
+
+func TestCoerceInputs(t *testing.T) {
+	type client struct{}
+	f := Flow{
+		Nodes: map[string]Node{
+			"source": {Id: "source", Cmd: "source"},
+			"add": {Id: "add", Cmd: "add", Inputs: []NodeInput{
+				{Key: "a", Type: NodeInputAnchor, DataType: "int", Anchors: []NodeAnchorTarget{{NodeId: "source", OutputKey: "a"}}},
+				{Key: "b", Type: NodeInputLiteral, DataType: "int", Literal: "2"},
+			}},
+			"prompt": {Id: "prompt", Cmd: "echo", Inputs: []NodeInput{
+				{Key: "a", Type: NodeInputAnchor, DataType: "int", Anchors: []NodeAnchorTarget{{NodeId: "source", OutputKey: "client"}}},
+			}},
+			"object": {Id: "object", Cmd: "echo", Inputs: []NodeInput{
+				{Key: "a", Type: NodeInputAnchor, DataType: "string", Anchors: []NodeAnchorTarget{{NodeId: "source", OutputKey: "object"}}},
+			}},
+		},
+	}
+	cmds := map[string]CMDer{
+		"source": NewFun(func(ctx context.Context, params map[string]interface{}) (rsp map[string]interface{}, err error) {
+			return map[string]interface{}{"a": "1", "client": &client{}, "object": map[string]interface{}{"a": 1}}, nil
+		}),
+		"echo": NewFun(func(ctx context.Context, params map[string]interface{}) (rsp map[string]interface{}, err error) {
+			return map[string]interface{}{"default": params["a"]}, nil
+		}),
+		"add": NewFun(func(ctx context.Context, params map[string]interface{}) (rsp map[string]interface{}, err error) {
+			return map[string]interface{}{"default": params["a"].(int) + params["b"].(int)}, nil
+		}),
+	}
+
+	rsp, err := newRunner(cmds, &f, 1).ExecNode(context.Background(), "add", false, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, rsp["default"])
+
+	// 无法无损转换的值原样交给 cmd，不在运行时报错
+	rsp, err = newRunner(cmds, &f, 1).ExecNode(context.Background(), "prompt", false, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, &client{}, rsp["default"])
+	rsp, err = newRunner(cmds, &f, 1).ExecNode(context.Background(), "object", false, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"a": 1}, rsp["default"])
+
+	assert.Equal(t, []interface{}{1, "x"}, ParsePortType("[]int").convert([]interface{}{"1", "x"}))
+	assert.Equal(t, "1.5", ParsePortType("int").convert("1.5"))
+}
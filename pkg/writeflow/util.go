@@ -1,41 +1,253 @@
 package writeflow
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/dop251/goja"
+	"github.com/spf13/cast"
 	"github.com/zbysir/gojsx"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 )
 
 // LookInterface i: {"a": 1, "b": {"d": 2}}, support key: a, b.d
+// key 是一个表达式，详见 CompileExpression
 func LookInterface(i map[string]interface{}, key string) (v interface{}, err error) {
-	r := goja.New()
-	for k, v := range i {
-		err = r.Set(k, v)
+	e, err := CompileExpression(key)
+	if err != nil {
+		return nil, err
+	}
+	return e.Eval(i)
+}
+
+// ForInterface 遍历 slice 或 array，nil 视为空
+func ForInterface(i interface{}, n func(i interface{})) (err error) {
+	if i == nil {
+		return nil
+	}
+	v := reflect.ValueOf(i)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for j := 0; j < v.Len(); j++ {
+			n(v.Index(j).Interface())
+		}
+		return nil
+	default:
+		return fmt.Errorf("can't iterate %T", i)
+	}
+}
+
+// ExpressionTimeout 是表达式的最长执行时间，超时后会被中断
+var ExpressionTimeout = 100 * time.Millisecond
+
+// Expression 是编译后的 js 表达式，如 data.a == 'b'，可以重复执行。
+// 也支持多条语句，如 var a = data.x; a > 1，结果为最后一条语句的值。
+// 执行时可以使用以下函数，与函数重名的变量会覆盖函数：
+//
+//	len(v)         字符串、数组或对象的长度
+//	lower(s)       转为小写
+//	contains(a, b) 字符串 a 是否包含 b，数组 a 是否包含元素 b，或者对象 a 是否有 key b
+//	json(v)        转为 json 字符串
+//
+// 执行表达式的 runtime 会被复用，内置对象与函数都被冻结，表达式修改了全局变量时 runtime 不会被复用。
+type Expression struct {
+	src     string
+	program *goja.Program
+}
+
+const expressionCacheSize = 1024
+
+var (
+	expressionCache     = map[string]*Expression{}
+	expressionCacheLock sync.RWMutex
+	expressionRuntimes  = sync.Pool{New: func() interface{} { return newExpressionRuntime() }}
+)
+
+// CompileExpression 编译表达式，相同的表达式只会编译一次
+func CompileExpression(src string) (*Expression, error) {
+	expressionCacheLock.RLock()
+	e, ok := expressionCache[src]
+	expressionCacheLock.RUnlock()
+	if ok {
+		return e, nil
+	}
+
+	// 在 with 块中执行：变量从 expressionVars 对象中读取而不是定义在全局对象上，let 与 const 声明的变量不会留在 runtime 中
+	program, err := goja.Compile("expression", fmt.Sprintf("with (%s) {\n%s\n}", expressionVars, src), false)
+	if err != nil {
+		return nil, fmt.Errorf("compile expression '%s' error: %w", src, err)
+	}
+	e = &Expression{src: src, program: program}
+
+	expressionCacheLock.Lock()
+	if len(expressionCache) >= expressionCacheSize {
+		expressionCache = map[string]*Expression{}
+	}
+	expressionCache[src] = e
+	expressionCacheLock.Unlock()
+
+	return e, nil
+}
+
+// Eval 使用 vars 作为变量执行表达式
+func (e *Expression) Eval(vars map[string]interface{}) (v interface{}, err error) {
+	er := expressionRuntimes.Get().(*expressionRuntime)
+	r := er.r
+	reuse := true
+	defer func() {
+		_ = r.GlobalObject().Set(expressionVars, goja.Undefined())
+		if reuse && er.clean() {
+			expressionRuntimes.Put(er)
+		}
+	}()
+
+	// 变量对象没有原型，表达式中的名字只会从变量、全局对象中查找
+	scope := r.NewObject()
+	_ = scope.SetPrototype(nil)
+	for k, v := range vars {
+		err = scope.DefineDataProperty(k, r.ToValue(v), goja.FLAG_TRUE, goja.FLAG_TRUE, goja.FLAG_TRUE)
 		if err != nil {
 			return nil, err
 		}
 	}
+	err = r.GlobalObject().Set(expressionVars, scope)
+	if err != nil {
+		reuse = false
+		return nil, err
+	}
 
-	out, err := r.RunScript("look_interface", fmt.Sprintf("%v", key))
+	interrupted := make(chan struct{})
+	timer := time.AfterFunc(ExpressionTimeout, func() {
+		r.Interrupt(fmt.Sprintf("timeout after %s", ExpressionTimeout))
+		close(interrupted)
+	})
+	out, err := r.RunProgram(e.program)
+	if !timer.Stop() {
+		// 等待 Interrupt 执行完成，否则它可能在 ClearInterrupt 之后执行
+		<-interrupted
+		r.ClearInterrupt()
+		reuse = false
+	}
 	if err != nil {
+		var interruptedErr *goja.InterruptedError
+		if errors.As(err, &interruptedErr) {
+			return nil, fmt.Errorf("exec expression '%s' error: %v", e.src, interruptedErr.Value())
+		}
 		return nil, gojsx.PrettifyException(err)
 	}
+	if out == nil {
+		return nil, nil
+	}
 	return out.Export(), nil
 }
 
-func ForInterface(i interface{}, n func(i interface{})) (err error) {
+// expressionHelpers 是表达式中可以使用的函数，同名的变量会覆盖它们
+var expressionHelpers = map[string]interface{}{
+	"len": func(v interface{}) int {
+		if v == nil {
+			return 0
+		}
+		if s, ok := v.(string); ok {
+			return utf8.RuneCountInString(s)
+		}
+		rv := reflect.ValueOf(v)
+		switch rv.Kind() {
+		case reflect.Slice, reflect.Array, reflect.Map:
+			return rv.Len()
+		}
+		return 0
+	},
+	"lower": strings.ToLower,
+	"contains": func(a interface{}, b interface{}) bool {
+		if s, ok := a.(string); ok {
+			return strings.Contains(s, cast.ToString(b))
+		}
+		rv := reflect.ValueOf(a)
+		switch rv.Kind() {
+		case reflect.Slice, reflect.Array:
+			for i := 0; i < rv.Len(); i++ {
+				if reflect.DeepEqual(rv.Index(i).Interface(), b) || cast.ToString(rv.Index(i).Interface()) == cast.ToString(b) {
+					return true
+				}
+			}
+		case reflect.Map:
+			for _, k := range rv.MapKeys() {
+				if cast.ToString(k.Interface()) == cast.ToString(b) {
+					return true
+				}
+			}
+		}
+		return false
+	},
+	"json": func(v interface{}) (string, error) {
+		bs, err := json.Marshal(v)
+		return string(bs), err
+	},
+}
+
+// expressionRuntimeInit 冻结内置对象，全局的内置对象也不能被替换或删除
+const expressionRuntimeInit = `(function () {
+	for (const name of Object.getOwnPropertyNames(globalThis)) {
+		const v = globalThis[name];
+		if (v !== globalThis && v !== null && (typeof v === 'object' || typeof v === 'function')) {
+			Object.freeze(v);
+			if (v.prototype) {
+				Object.freeze(v.prototype);
+			}
+		}
+		Object.defineProperty(globalThis, name, {writable: false, configurable: false});
+	}
+	Object.freeze(Object.getPrototypeOf(globalThis));
+	return Object.getOwnPropertyNames(globalThis).length;
+})()`
+
+// expressionVars 是保存表达式变量的全局对象的名字，表达式在 with (expressionVars) 中执行，
+// 这样变量可以使用与全局对象中不可修改的属性相同的名字，如 undefined、NaN、JSON
+const expressionVars = "__writeflow_vars__"
+
+var globalNamesProgram = goja.MustCompile("globals", "Object.getOwnPropertyNames(globalThis).length", false)
+
+type expressionRuntime struct {
+	r       *goja.Runtime
+	helpers *goja.Object
+	globals int64
+}
+
+func newExpressionRuntime() *expressionRuntime {
 	r := goja.New()
-	r.Set("data", i)
-	r.Set("n", n)
+	// 函数放在全局对象的原型上，这样变量可以覆盖同名的函数
+	helpers := r.NewObject()
+	for k, v := range expressionHelpers {
+		_ = helpers.Set(k, v)
+	}
+	_ = r.GlobalObject().SetPrototype(helpers)
 
-	_, err = r.RunScript("for_interface", fmt.Sprintf("data.forEach(n)"))
+	globals, err := r.RunString(expressionRuntimeInit)
 	if err != nil {
-		return gojsx.PrettifyException(err)
+		panic(err)
 	}
-	return nil
+	// 在冻结之后定义，每次执行时替换为新的变量对象
+	err = r.GlobalObject().DefineDataProperty(expressionVars, goja.Undefined(), goja.FLAG_TRUE, goja.FLAG_FALSE, goja.FLAG_FALSE)
+	if err != nil {
+		panic(err)
+	}
+	return &expressionRuntime{r: r, helpers: helpers, globals: globals.ToInteger() + 1}
+}
+
+// clean 返回 runtime 是否和初始化时一样，表达式声明了全局变量（如 var）时不能被复用
+func (e *expressionRuntime) clean() bool {
+	if !e.r.GlobalObject().Prototype().SameAs(e.helpers) {
+		return false
+	}
+	n, err := e.r.RunProgram(globalNamesProgram)
+	return err == nil && n.ToInteger() == e.globals
 }
 
 type SysFs struct {
@@ -49,3 +261,35 @@ func NewSysFs(root string) fs.FS {
 func (s SysFs) Open(name string) (fs.File, error) {
 	return os.Open(filepath.Join(s.root, name))
 }
+
+// ToDuration 支持 time.Duration 格式的字符串（如 "1m30s"）或者数字（单位为秒）
+func ToDuration(i interface{}) (time.Duration, error) {
+	switch v := i.(type) {
+	case nil:
+		return 0, nil
+	case time.Duration:
+		return v, nil
+	case string:
+		v = strings.TrimSpace(v)
+		if v == "" {
+			return 0, nil
+		}
+		d, err := time.ParseDuration(v)
+		if err == nil {
+			return d, nil
+		}
+	}
+
+	f, err := cast.ToFloat64E(i)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(f * float64(time.Second)), nil
+}
+
+func toInt(i interface{}) (int, error) {
+	if s, ok := i.(string); ok && strings.TrimSpace(s) == "" {
+		return 0, nil
+	}
+	return cast.ToIntE(i)
+}
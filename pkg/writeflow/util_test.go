@@ -0,0 +1,97 @@
+package writeflow
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestExpression(t *testing.T) {
+	vars := map[string]interface{}{
+		"data": map[string]interface{}{"name": "Bysir", "tags": []string{"a", "b"}},
+	}
+	cases := []struct {
+		exp    string
+		expect interface{}
+	}{
+		{exp: "data.name", expect: "Bysir"},
+		{exp: "lower(data.name) == 'bysir'", expect: true},
+		{exp: "len(data.tags)", expect: int64(2)},
+		{exp: "len(data.name)", expect: int64(5)},
+		{exp: "contains(data.tags, 'b')", expect: true},
+		{exp: "contains(data.name, 'sir')", expect: true},
+		{exp: "contains(data, 'age')", expect: false},
+		{exp: "json(data.tags)", expect: `["a","b"]`},
+		{exp: "data.name;", expect: "Bysir"},
+	}
+	for _, c := range cases {
+		v, err := LookInterface(vars, c.exp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, c.expect, v, c.exp)
+	}
+
+	_, err := CompileExpression("data ==")
+	assert.Error(t, err)
+
+	// 多条语句的结果为最后一条语句的值，重复执行不会重复声明
+	for i := 0; i < 2; i++ {
+		v, err := LookInterface(vars, "var a = data.name; let b = '!'; a + b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "Bysir!", v)
+	}
+
+	// 变量会覆盖同名的函数
+	v, err := LookInterface(map[string]interface{}{"len": 3}, "len + 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(4), v)
+
+	// 修改全局变量、函数与内置对象不会影响之后的表达式
+	for _, exp := range []string{"(x = 1)", "(len = 1)", "Array.prototype.foo = 1", "globalThis.y = 1", "JSON = null"} {
+		_, _ = LookInterface(vars, exp)
+	}
+	v, err = LookInterface(vars, "[typeof x, typeof y, typeof len, [].foo, typeof JSON, typeof a].join(',')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "undefined,undefined,function,,object,undefined", v)
+
+	// 变量可以与全局对象中不可修改的属性同名，不会影响之后的表达式
+	v, err = LookInterface(map[string]interface{}{"undefined": 1, "NaN": 2, "Infinity": 3, "JSON": 4, "constructor": 5}, "undefined + NaN + Infinity + JSON + constructor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(15), v)
+	v, err = LookInterface(vars, "[typeof undefined, isNaN(NaN), typeof JSON, typeof constructor].join(',')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "undefined,true,object,function", v)
+
+	_, err = LookInterface(vars, "(function(){ while(true){} })()")
+	assert.ErrorContains(t, err, "timeout")
+
+	v, err = LookInterface(vars, "len(data.tags)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(2), v)
+}
+
+func TestForInterface(t *testing.T) {
+	var items []interface{}
+	err := ForInterface([]int{1, 2}, func(i interface{}) {
+		items = append(items, i)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []interface{}{1, 2}, items)
+
+	assert.NoError(t, ForInterface(nil, func(i interface{}) {}))
+	assert.Error(t, ForInterface("abc", func(i interface{}) {}))
+}
@@ -0,0 +1,266 @@
+package writeflow
+
+import (
+	"errors"
+	"fmt"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+	"sort"
+)
+
+type FlowProblemType = string
+
+const (
+	ProblemUnknownCmd        FlowProblemType = "unknown_cmd"
+	ProblemDanglingAnchor    FlowProblemType = "dangling_anchor"
+	ProblemMissingInput      FlowProblemType = "missing_input"
+	ProblemCycle             FlowProblemType = "cycle"
+	ProblemUndeclaredOutput  FlowProblemType = "undeclared_output"
+	ProblemInvalidDefinition FlowProblemType = "invalid_definition" // 如脚本解析失败
+	ProblemInvalidExpression FlowProblemType = "invalid_expression" // 如 switch 的条件编译失败
+	ProblemTypeMismatch      FlowProblemType = "type_mismatch"      // 连线两端的类型不兼容，如将 llm.llm 连接到 string
+)
+
+// FlowProblem 描述流程中的一个静态问题，NodeId 与 InputKey 用于 UI 定位。
+type FlowProblem struct {
+	Type     FlowProblemType `json:"type"`
+	NodeId   string          `json:"node_id"`
+	InputKey string          `json:"input_key,omitempty"`
+	Message  string          `json:"message"`
+	Line     int             `json:"line,omitempty"` // 错误在节点定义中的位置，如脚本的第几行
+	Column   int             `json:"column,omitempty"`
+}
+
+// builtinCmds 是在 runner 中内置实现的 cmd，不需要注册。
+var builtinCmds = map[string]bool{
+	"_params":          true,
+	"_env":             true,
+	"_switch":          true,
+	"_for":             true,
+	"_while":           true,
+	"_try":             true,
+	"_race":            true,
+	"_coalesce":        true,
+	"_output":          true,
+	string(NothingCmd): true,
+}
+
+// injectOutputs 是由节点自己在运行时注入的输出（如 for 的 item），依赖它们的节点构成循环体，而不是环。
+var injectOutputs = map[string][]string{
+	"_for":   {"item", "index"},
+	"_while": {"item", "index"},
+}
+
+func (d *Flow) isInjectAnchor(a NodeAnchorTarget) bool {
+	n, ok := d.Nodes[a.NodeId]
+	if !ok {
+		return false
+	}
+	for _, k := range injectOutputs[n.Cmd] {
+		if k == a.OutputKey {
+			return true
+		}
+	}
+	return false
+}
+
+// expressions 返回节点中的表达式，input key -> 表达式
+func (n Node) expressions() map[string]string {
+	exprs := map[string]string{}
+	switch n.Cmd {
+	case "_switch":
+		// 除了 data 以外的 key 都是条件
+		for _, input := range n.Inputs {
+			if input.Key == "data" || input.Key == "_enable" || lo.Contains(execPolicyKeys, input.Key) {
+				continue
+			}
+			exprs[input.Key] = input.Key
+		}
+	case "_while":
+		for _, input := range n.Inputs {
+			if input.Key == "condition" && input.Type == NodeInputLiteral {
+				exprs[input.Key] = cast.ToString(input.Literal)
+			}
+		}
+	}
+	return exprs
+}
+
+func (d *Flow) sortedNodeIds() []string {
+	ids := make([]string, 0, len(d.Nodes))
+	for id := range d.Nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// FindCycle 返回流程中的第一个环，如 [a b a]，没有环则返回 nil。
+// 依赖 for.item 等注入值的连线不算做环。
+func (d *Flow) FindCycle() []string {
+	const (
+		white = iota
+		gray
+		black
+	)
+	color := map[string]int{}
+	var stack []string
+
+	var visit func(id string) []string
+	visit = func(id string) []string {
+		color[id] = gray
+		stack = append(stack, id)
+		for _, input := range d.Nodes[id].Inputs {
+			if input.Type != NodeInputAnchor {
+				continue
+			}
+			for _, a := range input.Anchors {
+				if _, ok := d.Nodes[a.NodeId]; !ok || d.isInjectAnchor(a) {
+					continue
+				}
+				switch color[a.NodeId] {
+				case gray:
+					for i, v := range stack {
+						if v == a.NodeId {
+							return append(append([]string{}, stack[i:]...), a.NodeId)
+						}
+					}
+				case white:
+					if c := visit(a.NodeId); c != nil {
+						return c
+					}
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		color[id] = black
+		return nil
+	}
+
+	for _, id := range d.sortedNodeIds() {
+		if color[id] == white {
+			if c := visit(id); c != nil {
+				return c
+			}
+		}
+	}
+
+	return nil
+}
+
+// Validate 在运行之前静态检查流程，cmds 为已注册的 cmd。
+func (d *Flow) Validate(cmds map[string]CMDer) (problems []FlowProblem) {
+	for _, id := range d.sortedNodeIds() {
+		node := d.Nodes[id]
+
+		if node.Cmd == "" {
+			problems = append(problems, FlowProblem{
+				Type:    ProblemUnknownCmd,
+				NodeId:  id,
+				Message: "cmd is not defined",
+			})
+		} else if node.BuiltCmd == nil && !builtinCmds[node.Cmd] {
+			if _, ok := cmds[node.Cmd]; !ok {
+				problems = append(problems, FlowProblem{
+					Type:    ProblemUnknownCmd,
+					NodeId:  id,
+					Message: fmt.Sprintf("cmd '%s' not found", node.Cmd),
+				})
+			}
+		}
+
+		exprs := node.expressions()
+		exprKeys := lo.Keys(exprs)
+		sort.Strings(exprKeys)
+		for _, key := range exprKeys {
+			if _, err := CompileExpression(exprs[key]); err != nil {
+				problems = append(problems, FlowProblem{
+					Type:     ProblemInvalidExpression,
+					NodeId:   id,
+					InputKey: key,
+					Message:  err.Error(),
+				})
+			}
+		}
+
+		for _, input := range node.Inputs {
+			if input.Type != NodeInputAnchor {
+				continue
+			}
+			if len(input.Anchors) == 0 && input.Required {
+				problems = append(problems, FlowProblem{
+					Type:     ProblemMissingInput,
+					NodeId:   id,
+					InputKey: input.Key,
+					Message:  fmt.Sprintf("params '%v' is required", input.Key),
+				})
+			}
+
+			for _, a := range input.Anchors {
+				target, ok := d.Nodes[a.NodeId]
+				if !ok {
+					problems = append(problems, FlowProblem{
+						Type:     ProblemDanglingAnchor,
+						NodeId:   id,
+						InputKey: input.Key,
+						Message:  fmt.Sprintf("anchor node '%s' not found", a.NodeId),
+					})
+					continue
+				}
+
+				if target.Outputs != nil && !d.isInjectAnchor(a) && !target.Outputs.Has(a.OutputKey) {
+					problems = append(problems, FlowProblem{
+						Type:     ProblemUndeclaredOutput,
+						NodeId:   id,
+						InputKey: input.Key,
+						Message:  fmt.Sprintf("node '%s' does not declare output '%s'", a.NodeId, a.OutputKey),
+					})
+				}
+			}
+		}
+
+		for _, e := range d.typeErrors(id) {
+			problems = append(problems, FlowProblem{
+				Type:     ProblemTypeMismatch,
+				NodeId:   id,
+				InputKey: e.InputKey,
+				Message:  e.Error(),
+			})
+		}
+	}
+
+	if c := d.FindCycle(); c != nil {
+		problems = append(problems, FlowProblem{
+			Type:    ProblemCycle,
+			NodeId:  c[0],
+			Message: newCycleError(c).Error(),
+		})
+	}
+
+	return problems
+}
+
+// ProblemFromError 将构建流程时的错误（如脚本解析失败）转为 FlowProblem
+func ProblemFromError(err error) (FlowProblem, bool) {
+	var e *ExecNodeError
+	if errors.As(err, &e) {
+		var te *PortTypeError
+		if errors.As(e.Cause, &te) {
+			return FlowProblem{
+				Type:     ProblemTypeMismatch,
+				NodeId:   e.NodeId,
+				InputKey: te.InputKey,
+				Message:  te.Error(),
+			}, true
+		}
+		return FlowProblem{
+			Type:    ProblemInvalidDefinition,
+			NodeId:  e.NodeId,
+			Message: e.Cause.Error(),
+			Line:    e.Line,
+			Column:  e.Column,
+		}, true
+	}
+
+	return FlowProblem{}, false
+}
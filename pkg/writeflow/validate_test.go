@@ -0,0 +1,127 @@
+package writeflow
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	f := Flow{
+		Nodes: map[string]Node{
+			"a": {
+				Id:  "a",
+				Cmd: "unknown",
+				Inputs: []NodeInput{
+					{
+						Key:     "default",
+						Type:    NodeInputAnchor,
+						Anchors: []NodeAnchorTarget{{NodeId: "b", OutputKey: "default"}},
+					},
+					{
+						Key:      "required",
+						Type:     NodeInputAnchor,
+						Required: true,
+					},
+					{
+						Key:     "deleted",
+						Type:    NodeInputAnchor,
+						Anchors: []NodeAnchorTarget{{NodeId: "x", OutputKey: "default"}},
+					},
+				},
+			},
+			"b": {
+				Id:  "b",
+				Cmd: "echo",
+				Inputs: []NodeInput{
+					{
+						Key:     "default",
+						Type:    NodeInputAnchor,
+						Anchors: []NodeAnchorTarget{{NodeId: "c", OutputKey: "undeclared"}},
+					},
+				},
+			},
+			"c": {
+				Id:      "c",
+				Cmd:     "echo",
+				Outputs: NodeOutputs{{Key: "default"}},
+				Inputs: []NodeInput{
+					{
+						Key:     "default",
+						Type:    NodeInputAnchor,
+						Anchors: []NodeAnchorTarget{{NodeId: "b", OutputKey: "default"}},
+					},
+				},
+			},
+		},
+	}
+
+	ps := f.Validate(map[string]CMDer{
+		"echo": NewFun(func(ctx context.Context, params map[string]interface{}) (rsp map[string]interface{}, err error) {
+			return params, nil
+		}),
+	})
+
+	assert.Equal(t, []FlowProblem{
+		{Type: ProblemUnknownCmd, NodeId: "a", Message: "cmd 'unknown' not found"},
+		{Type: ProblemMissingInput, NodeId: "a", InputKey: "required", Message: "params 'required' is required"},
+		{Type: ProblemDanglingAnchor, NodeId: "a", InputKey: "deleted", Message: "anchor node 'x' not found"},
+		{Type: ProblemUndeclaredOutput, NodeId: "b", InputKey: "default", Message: "node 'c' does not declare output 'undeclared'"},
+		{Type: ProblemCycle, NodeId: "b", Message: "cycle detected: b -> c -> b"},
+	}, ps)
+}
+
+func TestFindCycleSkipInject(t *testing.T) {
+	f := Flow{
+		Nodes: map[string]Node{
+			"a": {
+				Id:  "a",
+				Cmd: "_for",
+				Inputs: []NodeInput{
+					{Key: "item", Type: NodeInputAnchor, Anchors: []NodeAnchorTarget{{NodeId: "b", OutputKey: "default"}}},
+				},
+			},
+			"b": {
+				Id:  "b",
+				Cmd: "nothing",
+				Inputs: []NodeInput{
+					{Key: "default", Type: NodeInputAnchor, Anchors: []NodeAnchorTarget{{NodeId: "a", OutputKey: "item"}}},
+				},
+			},
+		},
+	}
+
+	assert.Nil(t, f.FindCycle())
+}
+
+func TestValidateExpression(t *testing.T) {
+	f := Flow{
+		Nodes: map[string]Node{
+			"s": {
+				Id:  "s",
+				Cmd: "_switch",
+				Inputs: []NodeInput{
+					{Key: "data", Type: NodeInputLiteral, Literal: "a"},
+					{Key: "data == 'a'", Type: NodeInputLiteral},
+					{Key: "data ==", Type: NodeInputLiteral},
+				},
+			},
+			"w": {
+				Id:  "w",
+				Cmd: "_while",
+				Inputs: []NodeInput{
+					{Key: "condition", Type: NodeInputLiteral, Literal: "data <"},
+				},
+			},
+		},
+	}
+
+	ps := f.Validate(nil)
+	if assert.Equal(t, 2, len(ps)) {
+		assert.Equal(t, ProblemInvalidExpression, ps[0].Type)
+		assert.Equal(t, "s", ps[0].NodeId)
+		assert.Equal(t, "data ==", ps[0].InputKey)
+		assert.Equal(t, "w", ps[1].NodeId)
+		assert.Equal(t, "condition", ps[1].InputKey)
+	}
+}
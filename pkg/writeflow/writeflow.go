@@ -63,24 +63,7 @@ func (m *ModuleForPlugin) Components() []Component {
 				DynamicInput:  c.Data.DynamicInput,
 				DynamicOutput: c.Data.DynamicOutput,
 				InputParams: lo.Map(c.Data.InputParams, func(item export.NodeInputParam, _ int) NodeInputParam {
-					return NodeInputParam{
-						Name:        Locales(item.Name),
-						Key:         item.Key,
-						InputType:   item.InputType,
-						Type:        item.Type,
-						DisplayType: item.DisplayType,
-						Options:     item.Options,
-						Optional:    item.Optional,
-						Dynamic:     item.Dynamic,
-						Value:       item.Value,
-						List:        item.List,
-						Anchors: lo.Map(item.Anchors, func(item export.NodeAnchorTarget, _ int) NodeAnchorTarget {
-							return NodeAnchorTarget{
-								NodeId:    item.NodeId,
-								OutputKey: item.OutputKey,
-							}
-						}),
-					}
+					return fromExportInputParam(item)
 				}),
 				OutputAnchors: lo.Map(c.Data.OutputAnchors, func(item export.NodeOutputAnchor, _ int) NodeOutputAnchor {
 					return NodeOutputAnchor{
@@ -106,6 +89,27 @@ func (m *ModuleForPlugin) Cmd() map[string]CMDer {
 	return mm
 }
 
+func fromExportInputParam(item export.NodeInputParam) NodeInputParam {
+	return NodeInputParam{
+		Name:        Locales(item.Name),
+		Key:         item.Key,
+		InputType:   item.InputType,
+		Type:        item.Type,
+		DisplayType: item.DisplayType,
+		Options:     item.Options,
+		Optional:    item.Optional,
+		Dynamic:     item.Dynamic,
+		Value:       item.Value,
+		List:        item.List,
+		Anchors: lo.Map(item.Anchors, func(item export.NodeAnchorTarget, _ int) NodeAnchorTarget {
+			return NodeAnchorTarget{
+				NodeId:    item.NodeId,
+				OutputKey: item.OutputKey,
+			}
+		}),
+	}
+}
+
 func (w *WriteFlow) RegisterPlugin(m export.Plugin) {
 	w.modules = append(w.modules, &ModuleForPlugin{inner: m})
 	for k, v := range m.Cmd() {
@@ -180,12 +184,26 @@ func (w *WriteFlow) GetComponentByKey(key string) (c Component, exist bool, err
 	return c, false, nil
 }
 
-func (w *WriteFlow) ExecNode(ctx context.Context, flow *Flow, initParams map[string]interface{}, parallel int) (rsp Map, err error) {
-	return w.core.ExecNode(ctx, flow, initParams, parallel)
+// HasCmd 返回 cmd 是否可用，即已经注册或者是 runner 内置的 cmd（如 _switch）
+func (w *WriteFlow) HasCmd(name string) bool {
+	if builtinCmds[name] {
+		return true
+	}
+	_, ok := w.core.cmds[name]
+	return ok
+}
+
+// ValidateFlow 使用已注册的 cmd 静态检查流程
+func (w *WriteFlow) ValidateFlow(flow *Flow) []FlowProblem {
+	return flow.Validate(w.core.cmds)
+}
+
+func (w *WriteFlow) ExecNode(ctx context.Context, flow *Flow, initParams map[string]interface{}, parallel int, ops ...ExecOption) (rsp Map, err error) {
+	return w.core.ExecNode(ctx, flow, initParams, parallel, ops...)
 }
 
-func (w *WriteFlow) ExecFlowAsync(ctx context.Context, flow *Flow, initParams map[string]interface{}, parallel int) (status chan NodeStatusLog, err error) {
-	return w.core.ExecFlowAsync(ctx, flow, initParams, parallel)
+func (w *WriteFlow) ExecFlowAsync(ctx context.Context, flow *Flow, initParams map[string]interface{}, parallel int, ops ...ExecOption) (status chan NodeStatusLog, err error) {
+	return w.core.ExecFlowAsync(ctx, flow, initParams, parallel, ops...)
 }
 
 type panicCmd struct {
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"github.com/samber/lo"
 	"github.com/spf13/cast"
-	"github.com/zbysir/writeflow/internal/pkg/keylock"
 	"github.com/zbysir/writeflow/internal/pkg/log"
 	"github.com/zbysir/writeflow/pkg/export"
 	"io"
@@ -40,6 +39,7 @@ const (
 type NodeInput struct {
 	Key      string
 	Type     NodeInputType // anchor, literal
+	DataType string        // 数据类型，如 string / number / []string / llm.llm，执行 cmd 前会转换为这个类型，见 ParsePortType
 	Literal  interface{}   // 字面量
 	List     bool
 	Required bool
@@ -72,6 +72,28 @@ type Node struct {
 	Cmd      string
 	BuiltCmd CMDer // go script, js script
 	Inputs   NodeInputs
+	Outputs  NodeOutputs // 组件声明的输出，为 nil 时表示不确定（如动态输出），不做检查
+}
+
+type NodeOutput struct {
+	Key  string
+	Type string // 数据类型，为空时不检查连线的类型
+}
+
+type NodeOutputs []NodeOutput
+
+func (n NodeOutputs) Has(key string) bool {
+	_, ok := n.Get(key)
+	return ok
+}
+
+func (n NodeOutputs) Get(key string) (NodeOutput, bool) {
+	for _, v := range n {
+		if v.Key == key {
+			return v, true
+		}
+	}
+	return NodeOutput{}, false
 }
 
 type ForItemNode struct {
@@ -93,6 +115,87 @@ func GetInputKeys(ctx context.Context) []string {
 	return nil
 }
 
+type statusReporterKey struct{}
+
+// WithStatusReporter 让 cmd 内部运行的子流程（如 sub_flow）可以报告节点状态
+func WithStatusReporter(ctx context.Context, onNodeStatusChange func(result NodeStatusLog)) context.Context {
+	return context.WithValue(ctx, statusReporterKey{}, onNodeStatusChange)
+}
+
+func GetStatusReporter(ctx context.Context) func(result NodeStatusLog) {
+	if v, ok := ctx.Value(statusReporterKey{}).(func(result NodeStatusLog)); ok {
+		return v
+	}
+	return nil
+}
+
+// maxNodeLogs 是一个节点最多保留的日志行数，超出的日志会被丢弃
+const maxNodeLogs = 1000
+
+// NodeLogger 收集 cmd 运行时输出的日志（如 js 的 console.log），日志会随节点状态一起报告。
+// nil 的 NodeLogger 会忽略所有日志，所以 cmd 可以直接使用 GetNodeLogger(ctx).Log()。
+type NodeLogger struct {
+	l      sync.Mutex
+	logs   []string
+	onLog  func(logs []string)
+	closed bool
+}
+
+func NewNodeLogger(onLog func(logs []string)) *NodeLogger {
+	return &NodeLogger{onLog: onLog}
+}
+
+func (l *NodeLogger) Log(line string) {
+	if l == nil {
+		return
+	}
+	l.l.Lock()
+	defer l.l.Unlock()
+	if l.closed || len(l.logs) > maxNodeLogs {
+		return
+	}
+	if len(l.logs) == maxNodeLogs {
+		line = "... (logs truncated)"
+	}
+	l.logs = append(l.logs, line)
+	if l.onLog != nil {
+		l.onLog(append([]string(nil), l.logs...))
+	}
+}
+
+func (l *NodeLogger) Logs() []string {
+	if l == nil {
+		return nil
+	}
+	l.l.Lock()
+	defer l.l.Unlock()
+	return append([]string(nil), l.logs...)
+}
+
+// Close 之后的日志会被忽略，如超时后仍在运行的 cmd 输出的日志
+func (l *NodeLogger) Close() {
+	if l == nil {
+		return
+	}
+	l.l.Lock()
+	l.closed = true
+	l.l.Unlock()
+}
+
+type nodeLoggerKey struct{}
+
+func WithNodeLogger(ctx context.Context, l *NodeLogger) context.Context {
+	return context.WithValue(ctx, nodeLoggerKey{}, l)
+}
+
+func GetNodeLogger(ctx context.Context) *NodeLogger {
+	if ctx == nil {
+		return nil
+	}
+	l, _ := ctx.Value(nodeLoggerKey{}).(*NodeLogger)
+	return l
+}
+
 type Nodes map[string]Node
 type Flow struct {
 	Nodes        Nodes // node id -> node
@@ -140,91 +243,185 @@ func (d *Flow) UsedComponents() (componentType []string) {
 
 type Map = map[string]interface{}
 
-func (f *WriteFlowCore) ExecFlowAsync(ctx context.Context, flow *Flow, initParams map[string]interface{}, parallel int) (results chan NodeStatusLog, err error) {
-	fr := newRunner(f.cmds, flow, parallel)
-	fr.global["params"] = initParams
+func (f *WriteFlowCore) ExecFlowAsync(ctx context.Context, flow *Flow, initParams map[string]interface{}, parallel int, ops ...ExecOption) (results chan NodeStatusLog, err error) {
+	o, err := newExecOption(ops)
+	if err != nil {
+		return nil, err
+	}
+	if c := flow.FindCycle(); c != nil {
+		return nil, NewExecNodeError(newCycleError(c), c[0])
+	}
+
+	ctx = withStack(ctx, Stack{})
 	rootNodes := flow.Nodes.GetRootNodes()
 
 	results = make(chan NodeStatusLog, 100)
+	onNodeStatusChange := func(result NodeStatusLog) {
+		results <- result
+	}
+
+	if o.executor == ExecutorTopo {
+		// 由调度器控制并发，节点内部串行计算输入
+		fr := newRunner(f.cmds, flow, 0)
+		fr.global["params"] = initParams
+		go func() {
+			defer close(results)
+			fr.emitPending(onNodeStatusChange)
+			fr.execTopo(ctx, lo.Map(rootNodes, func(item Node, _ int) string { return item.Id }), parallel, onNodeStatusChange)
+			fr.background.Wait()
+			fr.emitUnreachable(ctx, onNodeStatusChange)
+		}()
+		return
+	}
+
+	fr := newRunner(f.cmds, flow, parallel)
+	fr.global["params"] = initParams
 	go func() {
 		defer func() {
 			close(results)
 		}()
+		fr.emitPending(onNodeStatusChange)
 		var wg sync.WaitGroup
 		for _, node := range rootNodes {
 			node := node
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
-				_, _ = fr.ExecNode(ctx, node.Id, false, func(result NodeStatusLog) {
-					results <- result
-				})
+				_, _ = fr.ExecNode(ctx, node.Id, false, onNodeStatusChange)
 			}()
 		}
 
 		wg.Wait()
+		fr.background.Wait()
+		fr.emitUnreachable(ctx, onNodeStatusChange)
 	}()
 
 	return
 }
 
-func (f *WriteFlowCore) ExecNode(ctx context.Context, flow *Flow, initParams map[string]interface{}, parallel int) (rsp Map, err error) {
-	fr := newRunner(f.cmds, flow, parallel)
-	fr.global["params"] = initParams
+func (f *WriteFlowCore) ExecNode(ctx context.Context, flow *Flow, initParams map[string]interface{}, parallel int, ops ...ExecOption) (rsp Map, err error) {
+	o, err := newExecOption(ops)
+	if err != nil {
+		return Map{}, err
+	}
+	if c := flow.FindCycle(); c != nil {
+		return Map{}, NewExecNodeError(newCycleError(c), c[0])
+	}
+
 	_, ok := flow.Nodes[flow.OutputNodeId]
 	if !ok {
 		return Map{}, fmt.Errorf("output node %s not found", flow.OutputNodeId)
 	}
+	// 作为子流程运行时（如 sub_flow）节点 id 与父流程无关，不能沿用父流程的栈检查环
+	ctx = withStack(ctx, Stack{})
+
+	if o.executor == ExecutorTopo {
+		fr := newRunner(f.cmds, flow, 0)
+		fr.global["params"] = initParams
+		fr.execTopo(ctx, []string{flow.OutputNodeId}, parallel, GetStatusReporter(ctx))
+		return fr.execNodeOnce(ctx, flow.OutputNodeId, GetStatusReporter(ctx))
+	}
 
-	return fr.ExecNode(ctx, flow.OutputNodeId, false, nil)
+	fr := newRunner(f.cmds, flow, parallel)
+	fr.global["params"] = initParams
+	// 作为子流程运行时，状态通过 ctx 中的 reporter 报告给父节点
+	return fr.ExecNode(ctx, flow.OutputNodeId, false, GetStatusReporter(ctx))
 }
 
 type runner struct {
-	flowDef     *Flow
-	cmd         map[string]CMDer                  // id -> cmder
-	cmdRspCache map[string]*runnerRsp             // nodeId->key->value
-	inject      map[string]map[string]interface{} // nodeId->key->value
-	global      map[string]map[string]interface{} // key->key->value
-	l           sync.RWMutex                      // lock for map
-	keyLock     *keylock.KeyLock                  // lock for cmdRspCache (防止并发下缓存穿透)
-	limitChan   chan struct{}
+	flowDef   *Flow
+	cmd       map[string]CMDer                  // id -> cmder
+	futures   map[string]*nodeFuture            // nodeId -> 节点执行结果
+	inject    map[string]map[string]interface{} // nodeId->key->value
+	global    map[string]map[string]interface{} // key->key->value
+	reported  map[string]bool                   // 已经报告过状态的节点
+	skips     map[string]string                 // nodeId -> 没有执行的原因
+	l         sync.RWMutex                      // lock for map
+	limitChan chan struct{}
+
+	// 循环的每次迭代使用 fork 出的子 runner，scope 中的节点（循环体）在子 runner 中执行，其他节点交给 parent
+	parent *runner
+	scope  map[string]bool
+
+	background sync.WaitGroup // 节点返回后仍在运行的协程（如 _race 中落后的候选），运行结束前需要等待
 }
-type runnerRsp struct {
-	rsp Map
-	err error
+
+// nodeFuture 是节点的执行结果，同一个节点在一次运行中只会执行一次，
+// 其他依赖它的节点通过 done 等待结果，而不是轮询。
+type nodeFuture struct {
+	done    chan struct{}
+	rsp     Map
+	err     error
+	skipped bool // 节点被主动放弃（如 _race），结果不会被缓存
 }
 
-func (r *runner) getRspCache(nodeId string, key string) (v interface{}, exist bool, err error) {
-	r.l.RLock()
-	defer r.l.RUnlock()
+// getFuture 返回节点的 future，如果不存在则创建，created 表示调用方需要负责执行节点
+func (r *runner) getFuture(nodeId string) (fu *nodeFuture, created bool) {
+	r.l.Lock()
+	defer r.l.Unlock()
 
-	if r.cmdRspCache[nodeId] == nil {
-		return nil, false, nil
+	fu, ok := r.futures[nodeId]
+	if ok {
+		return fu, false
 	}
-	err = r.cmdRspCache[nodeId].err
-	if r.cmdRspCache[nodeId].rsp == nil {
-		return nil, true, err
+	fu = &nodeFuture{done: make(chan struct{})}
+	r.futures[nodeId] = fu
+	return fu, true
+}
+
+func (r *runner) dropFuture(nodeId string, fu *nodeFuture) {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	if r.futures[nodeId] == fu {
+		delete(r.futures, nodeId)
 	}
-	v, exist = r.cmdRspCache[nodeId].rsp[key]
-	return
 }
 
-func (r *runner) getInject(nodeId string, key string) (v interface{}, exist bool) {
-	r.l.RLock()
-	defer r.l.RUnlock()
+// execNodeOnce 执行节点并缓存结果，并发调用时只有第一个调用会执行，其余等待结果
+func (r *runner) execNodeOnce(ctx context.Context, nodeId string, onNodeStatusChange func(result NodeStatusLog)) (Map, error) {
+	if r.parent != nil && !r.scope[nodeId] {
+		return r.parent.execNodeOnce(ctx, nodeId, onNodeStatusChange)
+	}
 
-	if r.inject[nodeId] == nil {
-		return nil, false
+	// 等待解析路径上的节点会导致死锁，需要在等待之前检查环
+	if c := getStack(ctx).Cycle(nodeId); c != nil {
+		return nil, NewExecNodeError(newCycleError(c), nodeId)
+	}
+
+	fu, created := r.getFuture(nodeId)
+	if created {
+		fu.rsp, fu.err = r.ExecNode(ctx, nodeId, false, onNodeStatusChange)
+		// 被放弃的节点不缓存结果，之后依赖它的节点会重新执行
+		if _, ok := isSkipped(ctx, fu.err); ok {
+			fu.skipped = true
+			r.dropFuture(nodeId, fu)
+		}
+		close(fu.done)
+		return fu.rsp, fu.err
+	}
+
+	select {
+	case <-fu.done:
+		if fu.skipped && ctx.Err() == nil {
+			return r.execNodeOnce(ctx, nodeId, onNodeStatusChange)
+		}
+		return fu.rsp, fu.err
+	case <-ctx.Done():
+		return nil, NewExecNodeError(ctx.Err(), nodeId)
 	}
-	v, exist = r.inject[nodeId][key]
-	return
 }
 
-func (r *runner) setRspCache(nodeId string, rsp Map, err error) {
-	r.l.Lock()
-	defer r.l.Unlock()
+func (r *runner) getInject(nodeId string, key string) (v interface{}, exist bool) {
+	r.l.RLock()
+	if r.inject[nodeId] != nil {
+		v, exist = r.inject[nodeId][key]
+	}
+	r.l.RUnlock()
 
-	r.cmdRspCache[nodeId] = &runnerRsp{rsp: rsp, err: err}
+	if !exist && r.parent != nil {
+		return r.parent.getInject(nodeId, key)
+	}
 	return
 }
 
@@ -240,26 +437,167 @@ func (r *runner) setInject(nodeId string, k string, v interface{}) {
 	return
 }
 
+// fork 创建循环迭代使用的子 runner，scope 中的节点有独立的注入值与执行结果
+func (r *runner) fork(scope map[string]bool) *runner {
+	return &runner{
+		flowDef:   r.flowDef,
+		cmd:       r.cmd,
+		futures:   map[string]*nodeFuture{},
+		inject:    map[string]map[string]interface{}{},
+		global:    r.global,
+		limitChan: r.limitChan,
+		parent:    r,
+		scope:     scope,
+	}
+}
+
+// root 返回最外层的 runner，节点的状态记录在 root 中
+func (r *runner) root() *runner {
+	for r.parent != nil {
+		r = r.parent
+	}
+	return r
+}
+
+func (r *runner) setReported(nodeId string) {
+	r = r.root()
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	r.reported[nodeId] = true
+}
+
+// skipInputs 记录 inputs 依赖的节点没有执行的原因，已有原因时不覆盖
+func (r *runner) skipInputs(inputs NodeInputs, reason string) {
+	r = r.root()
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	for _, input := range inputs {
+		for _, a := range input.Anchors {
+			if _, ok := r.skips[a.NodeId]; !ok {
+				r.skips[a.NodeId] = reason
+			}
+		}
+	}
+}
+
+// emitPending 在运行开始时为所有节点报告 pending 状态
+func (r *runner) emitPending(onNodeStatusChange func(result NodeStatusLog)) {
+	for _, id := range r.flowDef.sortedNodeIds() {
+		onNodeStatusChange(NewNodeStatusLog(id, StatusPending, "", Map{}, time.Time{}, time.Time{}))
+	}
+}
+
+// emitUnreachable 在运行结束后为没有执行过的节点报告 unreachable 状态与原因，
+// 没有记录原因的节点沿着同样没有执行的下游节点查找原因。
+// 如果运行已经被取消，没有原因的节点报告 cancelled 状态。
+func (r *runner) emitUnreachable(ctx context.Context, onNodeStatusChange func(result NodeStatusLog)) {
+	r.l.RLock()
+	defer r.l.RUnlock()
+
+	dependents := map[string][]string{}
+	ids := r.flowDef.sortedNodeIds()
+	for _, id := range ids {
+		for _, input := range r.flowDef.Nodes[id].Inputs {
+			for _, a := range input.Anchors {
+				if !r.flowDef.isInjectAnchor(a) {
+					dependents[a.NodeId] = append(dependents[a.NodeId], id)
+				}
+			}
+		}
+	}
+
+	var reasonOf func(id string, visited map[string]bool) string
+	reasonOf = func(id string, visited map[string]bool) string {
+		if reason, ok := r.skips[id]; ok {
+			return reason
+		}
+		visited[id] = true
+		for _, d := range dependents[id] {
+			if visited[d] || r.reported[d] {
+				continue
+			}
+			if reason := reasonOf(d, visited); reason != "" {
+				return reason
+			}
+		}
+		return ""
+	}
+
+	for _, id := range ids {
+		if r.reported[id] {
+			continue
+		}
+		reason := reasonOf(id, map[string]bool{})
+		if reason == "" && ctx.Err() != nil {
+			onNodeStatusChange(NewNodeStatusLog(id, StatusCancelled, ctx.Err().Error(), Map{}, time.Time{}, time.Time{}))
+			continue
+		}
+		s := NewNodeStatusLog(id, StatusUnreachable, "", Map{}, time.Time{}, time.Time{})
+		s.Reason = reason
+		onNodeStatusChange(s)
+	}
+}
+
 func newRunner(cmd map[string]CMDer, flowDef *Flow, parallel int) *runner {
 	return &runner{
-		flowDef:     flowDef,
-		cmd:         cmd,
-		cmdRspCache: map[string]*runnerRsp{},
-		inject:      map[string]map[string]interface{}{},
-		global:      map[string]map[string]interface{}{},
-		l:           sync.RWMutex{},
-		keyLock:     keylock.NewKeyLock(),
-		limitChan:   make(chan struct{}, parallel),
+		flowDef:   flowDef,
+		cmd:       cmd,
+		futures:   map[string]*nodeFuture{},
+		inject:    map[string]map[string]interface{}{},
+		global:    map[string]map[string]interface{}{},
+		reported:  map[string]bool{},
+		skips:     map[string]string{},
+		l:         sync.RWMutex{},
+		limitChan: make(chan struct{}, parallel),
 	}
 }
 
 type ExecNodeError struct {
 	Cause  error
 	NodeId string
+	// Line 与 Column 是错误在节点定义（如模板）中的位置，从 1 开始，未知时为 0
+	Line   int
+	Column int
 }
 
 func NewExecNodeError(cause error, nodeId string) *ExecNodeError {
-	return &ExecNodeError{Cause: cause, NodeId: nodeId}
+	e := &ExecNodeError{Cause: cause, NodeId: nodeId}
+	e.Line, e.Column = positionOf(cause)
+	return e
+}
+
+// PositionError 是带有位置的错误，如模板第几行第几列解析失败，Line 与 Column 从 1 开始，未知时为 0
+type PositionError struct {
+	Line   int
+	Column int
+	Err    error
+}
+
+func (e *PositionError) Error() string {
+	if e.Column > 0 {
+		return fmt.Sprintf("line %d, column %d: %v", e.Line, e.Column, e.Err)
+	}
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *PositionError) Unwrap() error {
+	return e.Err
+}
+
+// positionOf 返回 err 中的位置，其他节点的错误（如依赖的节点失败）中的位置不属于当前节点，会被忽略
+func positionOf(err error) (line, column int) {
+	for err != nil {
+		switch e := err.(type) {
+		case *PositionError:
+			return e.Line, e.Column
+		case *ExecNodeError:
+			return 0, 0
+		}
+		err = errors.Unwrap(err)
+	}
+	return 0, 0
 }
 
 func (e *ExecNodeError) Error() string {
@@ -318,16 +656,44 @@ func NewMap(s map[string]interface{}) Map {
 	return s
 }
 
+// Stack 记录节点的解析路径，用于在运行时发现环。
 type Stack struct {
-	Nodes string
-
-	// stack
+	nodes []string
 }
 
 func (s Stack) Push(nodeId string) Stack {
+	nodes := make([]string, len(s.nodes), len(s.nodes)+1)
+	copy(nodes, s.nodes)
 	return Stack{
-		Nodes: s.Nodes + "->" + nodeId,
+		nodes: append(nodes, nodeId),
+	}
+}
+
+// Cycle 如果 nodeId 已经在路径中，返回从它开始的环，如 [a b a]
+func (s Stack) Cycle(nodeId string) []string {
+	for i, v := range s.nodes {
+		if v == nodeId {
+			return append(append([]string{}, s.nodes[i:]...), nodeId)
+		}
 	}
+	return nil
+}
+
+type stackKey struct{}
+
+func withStack(ctx context.Context, s Stack) context.Context {
+	return context.WithValue(ctx, stackKey{}, s)
+}
+
+func getStack(ctx context.Context) Stack {
+	s, _ := ctx.Value(stackKey{}).(Stack)
+	return s
+}
+
+var ErrCycle = errors.New("cycle detected")
+
+func newCycleError(cycle []string) error {
+	return fmt.Errorf("%w: %s", ErrCycle, strings.Join(cycle, " -> "))
 }
 
 func cloneMap(m map[string]interface{}) map[string]interface{} {
@@ -338,19 +704,103 @@ func cloneMap(m map[string]interface{}) map[string]interface{} {
 	return r
 }
 
+// calcInput 计算节点 nodeId 的输入，依赖的节点只会执行一次（nocache 为 true 时每次都执行）
+func (f *runner) calcInput(ctx context.Context, nodeId string, i NodeInput, nocache bool, onNodeStatusChange func(result NodeStatusLog)) (interface{}, error) {
+	switch i.Type {
+	case NodeInputLiteral:
+		return i.Literal, nil
+	case NodeInputAnchor:
+		if len(i.Anchors) == 0 && i.Required {
+			return nil, NewExecNodeError(fmt.Errorf("params '%v' is required", i.Key), nodeId)
+		}
+
+		var values []interface{}
+
+		for _, i := range i.Anchors {
+			v, ok := f.getInject(i.NodeId, i.OutputKey)
+			if ok {
+				return v, nil
+			}
+
+			var rsps Map
+			var err error
+			if nocache {
+				rsps, err = f.ExecNode(ctx, i.NodeId, nocache, onNodeStatusChange)
+			} else {
+				rsps, err = f.execNodeOnce(ctx, i.NodeId, onNodeStatusChange)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if rsps == nil {
+				continue
+			}
+
+			values = append(values, rsps[i.OutputKey])
+		}
+
+		if i.List {
+			return values, nil
+		} else if len(values) >= 1 {
+			return values[0], nil
+		} else {
+			return nil, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// getCmder 返回节点的 cmd，没有找到时返回 nil
+func (f *runner) getCmder(nodeDef Node) CMDer {
+	if nodeDef.BuiltCmd != nil {
+		return nodeDef.BuiltCmd
+	}
+	return f.cmd[nodeDef.Cmd]
+}
+
 func (f *runner) ExecNode(ctx context.Context, nodeId string, nocache bool, onNodeStatusChange func(result NodeStatusLog)) (rsp Map, err error) {
 	start := time.Now()
 	skipEmitChange := false
+	attempt := 0
+	unreachableReason := ""
+	var logger *NodeLogger
 	defer func() {
+		f.setReported(nodeId)
+		logger.Close()
 		if !skipEmitChange && onNodeStatusChange != nil {
-			if err != nil {
-				onNodeStatusChange(NewNodeStatusLog(nodeId, StatusFailed, err.Error(), Map{}, start, time.Now()))
+			var s NodeStatusLog
+			if reason, ok := isSkipped(ctx, err); ok {
+				s = NewNodeStatusLog(nodeId, StatusUnreachable, "", Map{}, start, time.Now())
+				s.Reason = reason
+			} else if unreachableReason != "" && err == nil {
+				s = NewNodeStatusLog(nodeId, StatusUnreachable, "", Map{}, start, time.Now())
+				s.Reason = unreachableReason
+			} else if errors.Is(err, context.Canceled) {
+				s = NewNodeStatusLog(nodeId, StatusCancelled, err.Error(), Map{}, start, time.Now())
+			} else if err != nil {
+				s = NewNodeStatusLog(nodeId, StatusFailed, err.Error(), Map{}, start, time.Now())
 			} else {
-				onNodeStatusChange(NewNodeStatusLog(nodeId, StatusSuccess, "", rsp, start, time.Now()))
+				s = NewNodeStatusLog(nodeId, StatusSuccess, "", rsp, start, time.Now())
 			}
+			s.Attempt = attempt
+			s.Logs = logger.Logs()
+			onNodeStatusChange(s)
 		}
 	}()
 
+	// 每执行一个节点前检查是否已经被取消
+	if err := ctx.Err(); err != nil {
+		return nil, NewExecNodeError(err, nodeId)
+	}
+
+	// 如果节点已经在解析路径上，说明存在环，继续执行会导致死循环
+	stack := getStack(ctx)
+	if c := stack.Cycle(nodeId); c != nil {
+		return nil, NewExecNodeError(newCycleError(c), nodeId)
+	}
+	ctx = withStack(ctx, stack.Push(nodeId))
+
 	nodeDef, ok := f.flowDef.Nodes[nodeId]
 	if !ok {
 		// 可能是前段没有删除干净，所以有空，先忽略错误
@@ -358,112 +808,42 @@ func (f *runner) ExecNode(ctx context.Context, nodeId string, nocache bool, onNo
 	}
 	inputs := nodeDef.Inputs
 
-	var calcInput = func(i NodeInput, nocache bool) (interface{}, error) {
-		switch i.Type {
-		case NodeInputLiteral:
-			return i.Literal, nil
-		case NodeInputAnchor:
-			if len(i.Anchors) == 0 && i.Required {
-				return nil, NewExecNodeError(fmt.Errorf("params '%v' is required", i.Key), nodeId)
-			}
-
-			var values []interface{}
-
-			for _, i := range i.Anchors {
-				v, ok := f.getInject(i.NodeId, i.OutputKey)
-				if ok {
-					return v, nil
-				}
-
-				lockKey := fmt.Sprintf("%s", i.NodeId)
-
-				// 加锁防止缓存穿透，这里有递归调用, 只能使用 tryLock
-				//log.Infof("----lock %s", lockKey)
-				for {
-					lock := f.keyLock.TryLock(lockKey)
-					if lock {
-						//log.Infof("---- lock %s", lockKey)
-						break
-					}
-					//log.Infof("----wait lock %s", lockKey)
-					time.Sleep(time.Millisecond * 100)
-				}
-
-				if !nocache {
-					v, ok, err = f.getRspCache(i.NodeId, i.OutputKey)
-					if err != nil {
-						//log.Infof("----Unlock %s", lockKey)
-						f.keyLock.Unlock(lockKey)
-						return nil, err
-					}
-					if ok {
-						values = append(values, v)
-						f.keyLock.Unlock(lockKey)
-					} else {
-						rsps, err := f.ExecNode(ctx, i.NodeId, nocache, onNodeStatusChange)
-						if err != nil {
-							//log.Infof("----Unlock %s", lockKey)
-							f.setRspCache(i.NodeId, Map{}, err)
-							f.keyLock.Unlock(lockKey)
-							return nil, err
-						}
-						if rsps == nil {
-							f.setRspCache(i.NodeId, rsps, nil)
-							f.keyLock.Unlock(lockKey)
-							continue
-						}
-
-						value := rsps[i.OutputKey]
-						values = append(values, value)
-
-						f.setRspCache(i.NodeId, rsps, nil)
-						//log.Infof("----Unlock %s", lockKey)
-						f.keyLock.Unlock(lockKey)
-					}
-				} else {
-					rsps, err := f.ExecNode(ctx, i.NodeId, nocache, onNodeStatusChange)
-					if err != nil {
-						//log.Infof("----Unlock %s", lockKey)
-						f.setRspCache(i.NodeId, Map{}, err)
-						f.keyLock.Unlock(lockKey)
-						return nil, err
-					}
-					if rsps == nil {
-						f.setRspCache(i.NodeId, rsps, nil)
-						f.keyLock.Unlock(lockKey)
-						continue
-					}
-
-					value, _ := rsps[i.OutputKey]
-					values = append(values, value)
-					f.keyLock.Unlock(lockKey)
-				}
-			}
-
-			if i.List {
-				return values, nil
-			} else if len(values) >= 1 {
-				return values[0], nil
-			} else {
-				return nil, nil
-			}
-		}
-
-		return nil, nil
+	var calcInput = func(ctx context.Context, i NodeInput, nocache bool) (interface{}, error) {
+		return f.calcInput(ctx, nodeId, i, nocache, onNodeStatusChange)
 	}
 
 	// 当 _enable 为 false 时，才会跳过节点。
 	enableInput, inputs, ok := inputs.PopKey("_enable")
 	if ok {
-		enable, err := calcInput(enableInput, nocache)
+		enable, err := calcInput(ctx, enableInput, nocache)
 		if err != nil {
 			return Map{}, err
 		}
 		if cast.ToBool(cast.ToString(enable)) == false {
+			unreachableReason = "_enable is false"
+			f.skipInputs(inputs, fmt.Sprintf("node '%s' is disabled (_enable is false)", nodeId))
 			return Map{}, nil
 		}
 	}
 
+	// 超时与重试策略，只对 cmd 生效
+	var policy execPolicy
+	for _, key := range execPolicyKeys {
+		var policyInput NodeInput
+		policyInput, inputs, ok = inputs.PopKey(key)
+		if !ok {
+			continue
+		}
+		v, err := calcInput(ctx, policyInput, nocache)
+		if err != nil {
+			return Map{}, err
+		}
+		err = policy.set(key, v)
+		if err != nil {
+			return Map{}, NewExecNodeError(err, nodeId)
+		}
+	}
+
 	//log.Infof("input %v: %+v", nodeId, inputs)
 	// switch 和 for 内置实现，不使用 cmd 逻辑。
 	switch nodeDef.Cmd {
@@ -476,13 +856,13 @@ func (f *runner) ExecNode(ctx context.Context, nodeId string, nocache bool, onNo
 		var data interface{}
 		dataInput, inputs, ok := inputs.PopKey("data")
 		if ok {
-			data, err = calcInput(dataInput, nocache)
+			data, err = calcInput(ctx, dataInput, nocache)
 			if err != nil {
 				return Map{}, err
 			}
 		}
 
-		for _, input := range inputs {
+		for i, input := range inputs {
 			condition := input.Key
 
 			v, err := LookInterface(map[string]interface{}{"data": data}, condition)
@@ -492,15 +872,20 @@ func (f *runner) ExecNode(ctx context.Context, nodeId string, nocache bool, onNo
 
 			// ToBool can't convert int64 to bool
 			if cast.ToBool(cast.ToString(v)) {
-				r, err := calcInput(input, nocache)
+				r, err := calcInput(ctx, input, nocache)
 				if err != nil {
 					return Map{}, err
 				}
 
+				// 其他分支不会执行
+				others := append(append(NodeInputs{}, inputs[:i]...), inputs[i+1:]...)
+				f.skipInputs(others, fmt.Sprintf("switch '%s' took branch '%s'", nodeId, input.Key))
+
 				return NewMap(map[string]interface{}{"default": r, "branch": input.Key}), nil
 			}
 		}
 
+		f.skipInputs(inputs, fmt.Sprintf("switch '%s' matched no branch", nodeId))
 		rsp = NewMap(map[string]interface{}{"default": nil, "branch": ""})
 	case "_for":
 		// for 的执行逻辑：
@@ -508,41 +893,105 @@ func (f *runner) ExecNode(ctx context.Context, nodeId string, nocache bool, onNo
 		// get data
 		var data interface{}
 		var itemInput NodeInput
+		concurrency := 1
 		for _, input := range inputs {
 			if input.Key == "data" {
-				data, err = calcInput(input, nocache)
+				data, err = calcInput(ctx, input, nocache)
 				if err != nil {
 					return Map{}, err
 				}
 			} else if input.Key == "item" {
 				itemInput = input
+			} else if input.Key == "concurrency" {
+				// 同时执行的迭代数，默认逐个执行
+				c, err := calcInput(ctx, input, nocache)
+				if err != nil {
+					return Map{}, err
+				}
+				if c != nil && c != "" {
+					concurrency = cast.ToInt(c)
+				}
 			}
 		}
 
-		var rsps []interface{}
-		var forError error
+		var items []interface{}
 		err := ForInterface(data, func(i interface{}) {
-			if forError != nil {
-				return
-			}
-			f.setInject(nodeId, "item", i)
-			r, err := calcInput(itemInput, true)
-			if err != nil {
-				forError = err
-			} else {
-				rsps = append(rsps, r)
-			}
+			items = append(items, i)
 		})
+		if err != nil {
+			return nil, NewExecNodeError(fmt.Errorf("for %T error: %w", data, err), nodeId)
+		}
 
+		rsps, err := f.execFor(ctx, nodeId, items, itemInput, concurrency, onNodeStatusChange)
 		if err != nil {
 			return nil, NewExecNodeError(fmt.Errorf("for %T error: %w", data, err), nodeId)
 		}
 
-		if forError != nil {
-			return nil, NewExecNodeError(fmt.Errorf("for %T error: %w", data, forError), nodeId)
+		if len(items) == 0 {
+			f.skipInputs(NodeInputs{itemInput}, fmt.Sprintf("for '%s' has no items", nodeId))
 		}
 
 		rsp = NewMap(map[string]interface{}{"default": rsps})
+	case "_try":
+		// try 执行失败时执行 fallback，并将错误信息作为 error 输出
+		tryInput, inputs, _ := inputs.PopKey("try")
+		fallbackInput, _, _ := inputs.PopKey("fallback")
+
+		v, tryErr := calcInput(ctx, tryInput, nocache)
+		if tryErr == nil {
+			f.skipInputs(NodeInputs{fallbackInput}, fmt.Sprintf("try '%s' succeeded", nodeId))
+			rsp = NewMap(map[string]interface{}{"default": v, "error": ""})
+			break
+		}
+		// 运行被取消时不执行 fallback
+		if errors.Is(tryErr, context.Canceled) {
+			return nil, tryErr
+		}
+
+		v, err = calcInput(ctx, fallbackInput, nocache)
+		if err != nil {
+			return nil, err
+		}
+		rsp = NewMap(map[string]interface{}{"default": v, "error": tryErr.Error()})
+	case "_race":
+		rsp, err = f.execRace(ctx, nodeId, inputs, nocache, onNodeStatusChange)
+		if err != nil {
+			return nil, NewExecNodeError(err, nodeId)
+		}
+	case "_coalesce":
+		rsp, err = f.execCoalesce(ctx, nodeId, inputs, nocache, onNodeStatusChange)
+		if err != nil {
+			return nil, err
+		}
+	case "_while":
+		var init interface{}
+		var bodyInput NodeInput
+		var condition string
+		var maxIterations int
+		for _, input := range inputs {
+			switch input.Key {
+			case "body":
+				bodyInput = input
+			case "init", "condition", "max_iterations":
+				v, err := calcInput(ctx, input, nocache)
+				if err != nil {
+					return Map{}, err
+				}
+				switch input.Key {
+				case "init":
+					init = v
+				case "condition":
+					condition = cast.ToString(v)
+				case "max_iterations":
+					maxIterations = cast.ToInt(cast.ToString(v))
+				}
+			}
+		}
+
+		rsp, err = f.execWhile(ctx, nodeId, init, bodyInput, condition, maxIterations, start, onNodeStatusChange)
+		if err != nil {
+			return nil, NewExecNodeError(fmt.Errorf("while error: %w", err), nodeId)
+		}
 	default:
 		dependValue := NewMap(nil)
 		var inputKeys []string
@@ -567,7 +1016,7 @@ func (f *runner) ExecNode(ctx context.Context, nodeId string, nocache bool, onNo
 						return
 					}
 
-					r, err := calcInput(i, nocache)
+					r, err := calcInput(ctx, i, nocache)
 					if err != nil {
 						calcErr = err
 					} else {
@@ -577,7 +1026,7 @@ func (f *runner) ExecNode(ctx context.Context, nodeId string, nocache bool, onNo
 					}
 				}(i)
 			default:
-				r, err := calcInput(i, nocache)
+				r, err := calcInput(ctx, i, nocache)
 				if err != nil {
 					log.Errorf("calcInput %v error: %v", nodeId, err)
 					return nil, err
@@ -595,11 +1044,25 @@ func (f *runner) ExecNode(ctx context.Context, nodeId string, nocache bool, onNo
 
 		// 流式输出特殊处理，异步读取输入的流并同步状态。
 		if nodeDef.Cmd == "_output" {
+			// 同步运行时没有状态回调
+			emit := func(result NodeStatusLog) {
+				if onNodeStatusChange != nil {
+					onNodeStatusChange(result)
+				}
+			}
 			var wg sync.WaitGroup
 			valueLock := sync.Mutex{}
 			dependValuex := cloneMap(dependValue)
+			var streaming []string
+			for k, v := range dependValue {
+				if _, ok := v.(export.Stream); ok {
+					streaming = append(streaming, k)
+				}
+			}
+			sort.Strings(streaming)
 			for k, v := range dependValue {
 				if steam, ok := v.(export.Stream); ok {
+					k := k
 					wg.Add(1)
 					reader := steam.NewReader()
 					go func() {
@@ -623,7 +1086,9 @@ func (f *runner) ExecNode(ctx context.Context, nodeId string, nocache bool, onNo
 								dd := cloneMap(dependValuex)
 								valueLock.Unlock()
 
-								onNodeStatusChange(NewNodeStatusLog(nodeId, StatusRunning, "", dd, start, time.Now()))
+								s := NewNodeStatusLog(nodeId, StatusRunning, "", dd, start, time.Now())
+								s.Streaming = streaming
+								emit(s)
 							}
 						}
 					}()
@@ -632,23 +1097,28 @@ func (f *runner) ExecNode(ctx context.Context, nodeId string, nocache bool, onNo
 
 			wg.Wait()
 			if err == nil {
-				onNodeStatusChange(NewNodeStatusLog(nodeId, StatusSuccess, "", dependValuex, start, time.Now()))
+				emit(NewNodeStatusLog(nodeId, StatusSuccess, "", dependValuex, start, time.Now()))
 			} else {
 				err = NewExecNodeError(fmt.Errorf("read strem error: %w", err), nodeDef.Id)
-				onNodeStatusChange(NewNodeStatusLog(nodeId, StatusFailed, err.Error(), dependValuex, start, time.Now()))
+				emit(NewNodeStatusLog(nodeId, StatusFailed, err.Error(), dependValuex, start, time.Now()))
 			}
 
 			skipEmitChange = true
-			return nil, err
+			if err != nil {
+				return nil, err
+			}
+			// 返回读取完成的值，作为子流程的输出
+			return dependValuex, nil
 		} else {
 			// 只有自定义 cmd 才需要报告 running 状态，特殊的 _for, _switch 不需要。
 			if onNodeStatusChange != nil {
 				onNodeStatusChange(NewNodeStatusLog(nodeId, StatusRunning, "", Map{}, start, time.Time{}))
 			}
 
-			// 其他命令需要等待直到流完成
+			// 其他命令需要等待直到流完成，支持流式处理的 cmd 除外
+			_, streaming := f.getCmder(nodeDef).(StreamCMDer)
 			for k, v := range dependValue {
-				if steam, ok := v.(export.Stream); ok {
+				if steam, ok := v.(export.Stream); ok && !streaming {
 					var ts []string
 					ts, err = steam.NewReader().ReadAll()
 					if err != nil {
@@ -666,6 +1136,13 @@ func (f *runner) ExecNode(ctx context.Context, nodeId string, nocache bool, onNo
 			}
 		}
 
+		// 依赖的节点执行期间可能已经被取消
+		if err := ctx.Err(); err != nil {
+			return nil, NewExecNodeError(err, nodeDef.Id)
+		}
+
+		coerceInputs(inputs, dependValue)
+
 		cmdName := nodeDef.Cmd
 		if cmdName == "" {
 			return nil, NewExecNodeError(fmt.Errorf("cmd is not defined"), nodeDef.Id)
@@ -683,7 +1160,36 @@ func (f *runner) ExecNode(ctx context.Context, nodeId string, nocache bool, onNo
 			}
 		}
 
-		rsp, err = HandlePanicCmd(cmder).Exec(WithInputKeys(ctx, inputKeys), dependValue)
+		// cmd 中运行的子流程的节点状态嵌套在当前节点下，如 "parent/child"
+		var reporter func(result NodeStatusLog)
+		if onNodeStatusChange != nil {
+			reporter = func(result NodeStatusLog) {
+				result.NodeId = nodeId + "/" + result.NodeId
+				onNodeStatusChange(result)
+			}
+		}
+		// cmd 输出的日志以 running 状态实时报告
+		logger = NewNodeLogger(func(logs []string) {
+			if onNodeStatusChange != nil {
+				s := NewNodeStatusLog(nodeId, StatusRunning, "", Map{}, start, time.Time{})
+				s.Logs = logs
+				onNodeStatusChange(s)
+			}
+		})
+		execCtx := WithNodeLogger(WithStatusReporter(WithInputKeys(ctx, inputKeys), reporter), logger)
+
+		if s, ok := cmder.(StreamCMDer); ok && HasStream(dependValue) {
+			cmder = streamCmd{s: s}
+		}
+
+		rsp, attempt, err = policy.exec(execCtx, HandlePanicCmd(cmder), dependValue, func(n int, lastErr error) {
+			// 每次重试都报告 running 状态，UI 可以显示重试次数
+			if n > 1 && onNodeStatusChange != nil {
+				s := NewNodeStatusLog(nodeId, StatusRunning, lastErr.Error(), Map{}, start, time.Time{})
+				s.Attempt = n
+				onNodeStatusChange(s)
+			}
+		})
 		if err != nil {
 			return nil, NewExecNodeError(err, nodeDef.Id)
 		}
@@ -4,7 +4,11 @@ import (
 	"context"
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestSwitch(t *testing.T) {
@@ -170,3 +174,914 @@ func TestFor(t *testing.T) {
 
 	assert.Equal(t, []interface{}{"hi: a", "hi: b", "hi: c"}, rsp["default"])
 }
+
+func TestCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	f := Flow{
+		Nodes: map[string]Node{
+			"a": {
+				Id:  "a",
+				Cmd: "nothing",
+				Inputs: []NodeInput{
+					{
+						Key:     "default",
+						Type:    "anchor",
+						Anchors: []NodeAnchorTarget{{NodeId: "b", OutputKey: "default"}},
+					},
+				},
+			},
+			"b": {
+				Id:  "b",
+				Cmd: "nothing",
+				Inputs: []NodeInput{
+					{
+						Key:     "default",
+						Type:    "anchor",
+						Anchors: []NodeAnchorTarget{{NodeId: "c", OutputKey: "default"}},
+					},
+				},
+			},
+			"c": {
+				Id:  "c",
+				Cmd: "cancel",
+			},
+		},
+		OutputNodeId: "a",
+	}
+
+	core := NewWriteFlowCore()
+	core.RegisterCmd("cancel", NewFun(func(ctx context.Context, params map[string]interface{}) (rsp map[string]interface{}, err error) {
+		cancel()
+		return map[string]interface{}{"default": "c"}, nil
+	}))
+
+	results, err := core.ExecFlowAsync(ctx, &f, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := map[string]NodeStatus{}
+	for r := range results {
+		status[r.NodeId] = r.Status
+	}
+
+	assert.Equal(t, StatusSuccess, status["c"])
+	assert.Equal(t, StatusCancelled, status["b"])
+	assert.Equal(t, StatusCancelled, status["a"])
+}
+
+func TestRetry(t *testing.T) {
+	f := Flow{
+		Nodes: map[string]Node{
+			"a": {
+				Id:  "a",
+				Cmd: "flaky",
+				Inputs: []NodeInput{
+					{Key: "_retry", Type: "literal", Literal: "2"},
+					{Key: "_retry_backoff", Type: "literal", Literal: "1ms"},
+				},
+			},
+			"b": {
+				Id:  "b",
+				Cmd: "slow",
+				Inputs: []NodeInput{
+					{Key: "_timeout", Type: "literal", Literal: 0.01},
+				},
+			},
+		},
+	}
+
+	times := 0
+	r := newRunner(map[string]CMDer{
+		"flaky": NewFun(func(ctx context.Context, params map[string]interface{}) (rsp map[string]interface{}, err error) {
+			times++
+			if times < 3 {
+				return nil, fmt.Errorf("flaky %d", times)
+			}
+			return map[string]interface{}{"default": times}, nil
+		}),
+		"slow": NewFun(func(ctx context.Context, params map[string]interface{}) (rsp map[string]interface{}, err error) {
+			time.Sleep(time.Second)
+			return nil, nil
+		}),
+	}, &f, 1)
+
+	var attempts []int
+	rsp, err := r.ExecNode(context.Background(), "a", false, func(result NodeStatusLog) {
+		if result.Attempt != 0 {
+			attempts = append(attempts, result.Attempt)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 3, rsp["default"])
+	// running 2, running 3, success 3
+	assert.Equal(t, []int{2, 3, 3}, attempts)
+
+	_, err = r.ExecNode(context.Background(), "b", false, nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+// 返回流的 cmd 在超时时间内仍然可以继续输出
+func TestTimeoutStream(t *testing.T) {
+	f := Flow{
+		Nodes: map[string]Node{
+			"llm": {Id: "llm", Cmd: "llm", Inputs: []NodeInput{{Key: "_timeout", Type: "literal", Literal: "10s"}}},
+			"slow": {Id: "slow", Cmd: "llm", Inputs: []NodeInput{
+				{Key: "_timeout", Type: "literal", Literal: "50ms"},
+				{Key: "delay", Type: "literal", Literal: "1s"},
+			}},
+			"out":      {Id: "out", Cmd: "_output", Inputs: []NodeInput{{Key: "default", Type: "anchor", Anchors: []NodeAnchorTarget{{NodeId: "llm", OutputKey: "default"}}}}},
+			"slow_out": {Id: "slow_out", Cmd: "_output", Inputs: []NodeInput{{Key: "default", Type: "anchor", Anchors: []NodeAnchorTarget{{NodeId: "slow", OutputKey: "default"}}}}},
+		},
+	}
+
+	r := newRunner(map[string]CMDer{
+		"llm": NewFun(func(ctx context.Context, params map[string]interface{}) (rsp map[string]interface{}, err error) {
+			delay, _ := ToDuration(params["delay"])
+			s := NewStream()
+			go func() {
+				for _, t := range []string{"a", "b", "c"} {
+					select {
+					case <-ctx.Done():
+						s.Close(ctx.Err())
+						return
+					case <-time.After(10*time.Millisecond + delay):
+					}
+					s.Append(t)
+				}
+				s.Close(nil)
+			}()
+			return map[string]interface{}{"default": s}, nil
+		}),
+	}, &f, 0)
+
+	rsp, err := r.ExecNode(context.Background(), "out", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "abc", rsp["default"])
+
+	// 超时仍然会结束流
+	_, err = r.ExecNode(context.Background(), "slow_out", false, nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestCycle(t *testing.T) {
+	f := Flow{
+		Nodes: map[string]Node{
+			"a": {
+				Id:  "a",
+				Cmd: "nothing",
+				Inputs: []NodeInput{
+					{Key: "default", Type: "anchor", Anchors: []NodeAnchorTarget{{NodeId: "b", OutputKey: "default"}}},
+				},
+			},
+			"b": {
+				Id:  "b",
+				Cmd: "nothing",
+				Inputs: []NodeInput{
+					{Key: "default", Type: "anchor", Anchors: []NodeAnchorTarget{{NodeId: "a", OutputKey: "default"}}},
+				},
+			},
+		},
+		OutputNodeId: "a",
+	}
+
+	r := newRunner(nil, &f, 1)
+	_, err := r.ExecNode(context.Background(), "a", false, nil)
+	assert.ErrorIs(t, err, ErrCycle)
+	assert.Contains(t, err.Error(), "a -> b -> a")
+
+	_, err = NewWriteFlowCore().ExecNode(context.Background(), &f, nil, 1)
+	assert.ErrorIs(t, err, ErrCycle)
+}
+
+// fanInFlow 构造一个宽扇入的图：src -> mid_0..mid_n -> sink，所有 mid 共享 src
+func fanInFlow(width int) *Flow {
+	nodes := map[string]Node{
+		"src": {Id: "src", Cmd: "src"},
+	}
+	var sinkInputs []NodeInput
+	for i := 0; i < width; i++ {
+		id := fmt.Sprintf("mid_%d", i)
+		nodes[id] = Node{
+			Id:  id,
+			Cmd: "nothing",
+			Inputs: []NodeInput{
+				{Key: "default", Type: "anchor", Anchors: []NodeAnchorTarget{{NodeId: "src", OutputKey: "default"}}},
+			},
+		}
+		sinkInputs = append(sinkInputs, NodeInput{Key: id, Type: "anchor", Anchors: []NodeAnchorTarget{{NodeId: id, OutputKey: "default"}}})
+	}
+	nodes["sink"] = Node{Id: "sink", Cmd: "nothing", Inputs: sinkInputs}
+
+	return &Flow{Nodes: nodes, OutputNodeId: "sink"}
+}
+
+func newFanInCore(times *int32) *WriteFlowCore {
+	core := NewWriteFlowCore()
+	core.RegisterCmd("src", NewFun(func(ctx context.Context, params map[string]interface{}) (rsp map[string]interface{}, err error) {
+		atomic.AddInt32(times, 1)
+		time.Sleep(time.Millisecond)
+		return map[string]interface{}{"default": 1}, nil
+	}))
+	return core
+}
+
+func TestFanIn(t *testing.T) {
+	var times int32
+	rsp, err := newFanInCore(&times).ExecNode(context.Background(), fanInFlow(50), nil, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 50, len(rsp))
+	assert.Equal(t, int32(1), times)
+}
+
+func BenchmarkFanIn(b *testing.B) {
+	for _, width := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("width_%d", width), func(b *testing.B) {
+			var times int32
+			core := newFanInCore(&times)
+			flow := fanInFlow(width)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err := core.ExecNode(context.Background(), flow, nil, 8)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestTopoExecutor(t *testing.T) {
+	nodes := map[string]Node{
+		"s": {
+			Id:  "s",
+			Cmd: "_switch",
+			Inputs: []NodeInput{
+				{Key: "data", Type: "literal", Literal: "b"},
+				{Key: "data=='c'", Type: "anchor", Anchors: []NodeAnchorTarget{{NodeId: "c", OutputKey: "default"}}},
+				{Key: "data=='b'", Type: "anchor", Anchors: []NodeAnchorTarget{{NodeId: "b", OutputKey: "default"}}},
+			},
+		},
+		"b": {Id: "b", Cmd: "nothing", Inputs: []NodeInput{{Key: "default", Type: "literal", Literal: "b"}}},
+		"c": {Id: "c", Cmd: "branch_c"},
+	}
+	sinkInputs := []NodeInput{{Key: "switch", Type: "anchor", Anchors: []NodeAnchorTarget{{NodeId: "s", OutputKey: "default"}}}}
+	for i := 0; i < 10; i++ {
+		id := fmt.Sprintf("work_%d", i)
+		nodes[id] = Node{Id: id, Cmd: "work"}
+		sinkInputs = append(sinkInputs, NodeInput{Key: id, Type: "anchor", Anchors: []NodeAnchorTarget{{NodeId: id, OutputKey: "default"}}})
+	}
+	nodes["sink"] = Node{Id: "sink", Cmd: "nothing", Inputs: sinkInputs}
+	f := Flow{Nodes: nodes, OutputNodeId: "sink"}
+
+	var running, maxRunning, branchC int32
+	core := NewWriteFlowCore()
+	core.RegisterCmd("work", NewFun(func(ctx context.Context, params map[string]interface{}) (rsp map[string]interface{}, err error) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return map[string]interface{}{"default": 1}, nil
+	}))
+	core.RegisterCmd("branch_c", NewFun(func(ctx context.Context, params map[string]interface{}) (rsp map[string]interface{}, err error) {
+		atomic.AddInt32(&branchC, 1)
+		return map[string]interface{}{"default": "c"}, nil
+	}))
+
+	results, err := core.ExecFlowAsync(context.Background(), &f, nil, 3, WithExecutor(ExecutorTopo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := map[string]NodeStatus{}
+	var sink Map
+	for r := range results {
+		status[r.NodeId] = r.Status
+		if r.NodeId == "sink" {
+			sink = r.ResultRaw
+		}
+	}
+
+	// work 节点不是懒输入，都由 execTopo 调度，同时执行的节点数等于 parallel
+	assert.Equal(t, int32(3), maxRunning)
+	assert.Equal(t, int32(0), branchC)
+	assert.Equal(t, StatusSuccess, status["sink"])
+	assert.Equal(t, "b", sink["switch"])
+	assert.Equal(t, 11, len(sink))
+
+	rsp, err := core.ExecNode(context.Background(), &f, nil, 2, WithExecutor(ExecutorTopo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "b", rsp["switch"])
+
+	_, err = core.ExecNode(context.Background(), &f, nil, 2, WithExecutor("unknown"))
+	assert.Error(t, err)
+}
+
+func TestUnreachable(t *testing.T) {
+	anchor := func(key, nodeId string) NodeInput {
+		return NodeInput{Key: key, Type: "anchor", Anchors: []NodeAnchorTarget{{NodeId: nodeId, OutputKey: "default"}}}
+	}
+	f := Flow{
+		Nodes: map[string]Node{
+			"s": {
+				Id:  "s",
+				Cmd: "_switch",
+				Inputs: []NodeInput{
+					{Key: "data", Type: "literal", Literal: "b"},
+					anchor("data=='c'", "c"),
+					anchor("data=='b'", "b"),
+				},
+			},
+			"b":    {Id: "b", Cmd: "nothing", Inputs: []NodeInput{{Key: "default", Type: "literal", Literal: "b"}}},
+			"c":    {Id: "c", Cmd: "nothing", Inputs: []NodeInput{anchor("default", "c_up")}},
+			"c_up": {Id: "c_up", Cmd: "nothing"},
+			"d": {
+				Id:  "d",
+				Cmd: "nothing",
+				Inputs: []NodeInput{
+					{Key: "_enable", Type: "literal", Literal: false},
+					anchor("default", "d_up"),
+				},
+			},
+			"d_up": {Id: "d_up", Cmd: "nothing"},
+		},
+	}
+
+	for _, executor := range []Executor{ExecutorPull, ExecutorTopo} {
+		results, err := NewWriteFlowCore().ExecFlowAsync(context.Background(), &f, nil, 2, WithExecutor(executor))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		first := map[string]NodeStatus{}
+		last := map[string]NodeStatusLog{}
+		for r := range results {
+			if _, ok := first[r.NodeId]; !ok {
+				first[r.NodeId] = r.Status
+			}
+			last[r.NodeId] = r
+		}
+
+		for id := range f.Nodes {
+			assert.Equal(t, StatusPending, first[id], id)
+		}
+		assert.Equal(t, StatusSuccess, last["b"].Status)
+		assert.Equal(t, StatusSuccess, last["s"].Status)
+		for _, id := range []string{"c", "c_up"} {
+			assert.Equal(t, StatusUnreachable, last[id].Status, id)
+			assert.Equal(t, "switch 's' took branch 'data=='b''", last[id].Reason, id)
+		}
+		assert.Equal(t, StatusUnreachable, last["d"].Status)
+		assert.Equal(t, "_enable is false", last["d"].Reason)
+		assert.Equal(t, StatusUnreachable, last["d_up"].Status)
+		assert.Equal(t, "node 'd' is disabled (_enable is false)", last["d_up"].Reason)
+	}
+}
+
+func TestNestedStatus(t *testing.T) {
+	inner := Flow{
+		Nodes: map[string]Node{
+			"x": {Id: "x", Cmd: "_output", Inputs: []NodeInput{{Key: "a", Type: "literal", Literal: 1}}},
+		},
+		OutputNodeId: "x",
+	}
+	outer := Flow{
+		Nodes: map[string]Node{
+			"p": {Id: "p", Cmd: "sub"},
+		},
+	}
+
+	core := NewWriteFlowCore()
+	core.RegisterCmd("sub", NewFun(func(ctx context.Context, params map[string]interface{}) (rsp map[string]interface{}, err error) {
+		return core.ExecNode(ctx, &inner, nil, 1)
+	}))
+
+	results, err := core.ExecFlowAsync(context.Background(), &outer, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := map[string]NodeStatusLog{}
+	for r := range results {
+		last[r.NodeId] = r
+	}
+
+	assert.Equal(t, StatusSuccess, last["p/x"].Status)
+	assert.Equal(t, StatusSuccess, last["p"].Status)
+	assert.Equal(t, 1, last["p"].ResultRaw["a"])
+
+	// 同步运行没有状态回调
+	rsp, err := core.ExecNode(context.Background(), &inner, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, rsp["a"])
+}
+
+func TestForParallel(t *testing.T) {
+	var data []int
+	for i := 0; i < 20; i++ {
+		data = append(data, i)
+	}
+	anchor := func(key, nodeId, outputKey string) NodeInput {
+		return NodeInput{Key: key, Type: "anchor", Anchors: []NodeAnchorTarget{{NodeId: nodeId, OutputKey: outputKey}}}
+	}
+	f := Flow{
+		Nodes: map[string]Node{
+			"for": {
+				Id:  "for",
+				Cmd: "_for",
+				Inputs: []NodeInput{
+					{Key: "data", Type: "literal", Literal: data},
+					anchor("item", "work", "default"),
+					{Key: "concurrency", Type: "literal", Literal: "4"},
+				},
+			},
+			"work": {
+				Id:  "work",
+				Cmd: "work",
+				Inputs: []NodeInput{
+					anchor("item", "for", "item"),
+					anchor("index", "for", "index"),
+					anchor("factor", "const", "default"),
+				},
+			},
+			"const": {Id: "const", Cmd: "const"},
+		},
+		OutputNodeId: "for",
+	}
+
+	var running, maxRunning, constTimes int32
+	r := newRunner(map[string]CMDer{
+		"const": NewFun(func(ctx context.Context, params map[string]interface{}) (rsp map[string]interface{}, err error) {
+			atomic.AddInt32(&constTimes, 1)
+			return map[string]interface{}{"default": 2}, nil
+		}),
+		"work": NewFun(func(ctx context.Context, params map[string]interface{}) (rsp map[string]interface{}, err error) {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&maxRunning)
+				if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+
+			item := params["item"].(int)
+			assert.Equal(t, item, params["index"])
+			rsp = map[string]interface{}{"default": item * params["factor"].(int)}
+			switch item {
+			case 3:
+				rsp["_for_break"] = "continue"
+			case 15:
+				rsp["_for_break"] = "break"
+			}
+			return rsp, nil
+		}),
+	}, &f, 1)
+
+	rsp, err := r.ExecNode(context.Background(), "for", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var expect []interface{}
+	for i := 0; i < 15; i++ {
+		if i != 3 {
+			expect = append(expect, i*2)
+		}
+	}
+	assert.Equal(t, expect, rsp["default"])
+	assert.Equal(t, int32(1), constTimes)
+	assert.LessOrEqual(t, maxRunning, int32(4))
+	assert.Greater(t, maxRunning, int32(1))
+}
+
+func TestWhile(t *testing.T) {
+	f := Flow{
+		Nodes: map[string]Node{
+			"w": {
+				Id:  "w",
+				Cmd: "_while",
+				Inputs: []NodeInput{
+					{Key: "init", Type: "literal", Literal: 0},
+					{Key: "condition", Type: "literal", Literal: "data < 3"},
+					{Key: "max_iterations", Type: "literal", Literal: "10"},
+					{Key: "body", Type: "anchor", Anchors: []NodeAnchorTarget{{NodeId: "inc", OutputKey: "default"}}},
+				},
+			},
+			"inc": {
+				Id:  "inc",
+				Cmd: "inc",
+				Inputs: []NodeInput{
+					{Key: "default", Type: "anchor", Anchors: []NodeAnchorTarget{{NodeId: "w", OutputKey: "item"}}},
+				},
+			},
+		},
+		OutputNodeId: "w",
+	}
+	cmds := map[string]CMDer{
+		"inc": NewFun(func(ctx context.Context, params map[string]interface{}) (rsp map[string]interface{}, err error) {
+			return map[string]interface{}{"default": params["default"].(int) + 1}, nil
+		}),
+	}
+
+	var iterations []interface{}
+	rsp, err := newRunner(cmds, &f, 1).ExecNode(context.Background(), "w", false, func(result NodeStatusLog) {
+		if result.NodeId == "w" && result.Status == StatusRunning {
+			iterations = append(iterations, result.ResultRaw["index"])
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 3, rsp["default"])
+	assert.Equal(t, 3, rsp["iterations"])
+	assert.Equal(t, []interface{}{0, 1, 2}, iterations)
+
+	f.Nodes["w"].Inputs[2].Literal = "2"
+	_, err = newRunner(cmds, &f, 1).ExecNode(context.Background(), "w", false, nil)
+	assert.ErrorContains(t, err, "still holds after 2 iterations")
+}
+
+func TestTry(t *testing.T) {
+	f := Flow{
+		Nodes: map[string]Node{
+			"try": {
+				Id:  "try",
+				Cmd: "_try",
+				Inputs: []NodeInput{
+					{Key: "try", Type: "anchor", Anchors: []NodeAnchorTarget{{NodeId: "a", OutputKey: "default"}}},
+					{Key: "fallback", Type: "anchor", Anchors: []NodeAnchorTarget{{NodeId: "b", OutputKey: "default"}}},
+				},
+			},
+			"a": {Id: "a", Cmd: "fail"},
+			"b": {Id: "b", Cmd: "nothing", Inputs: []NodeInput{{Key: "default", Type: "literal", Literal: "canned answer"}}},
+		},
+		OutputNodeId: "try",
+	}
+	cmds := map[string]CMDer{
+		"fail": NewFun(func(ctx context.Context, params map[string]interface{}) (rsp map[string]interface{}, err error) {
+			return nil, fmt.Errorf("openai is down")
+		}),
+		"ok": NewFun(func(ctx context.Context, params map[string]interface{}) (rsp map[string]interface{}, err error) {
+			return map[string]interface{}{"default": "answer"}, nil
+		}),
+	}
+
+	rsp, err := newRunner(cmds, &f, 1).ExecNode(context.Background(), "try", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "canned answer", rsp["default"])
+	assert.Contains(t, rsp["error"], "openai is down")
+
+	a := f.Nodes["a"]
+	a.Cmd = "ok"
+	f.Nodes["a"] = a
+	rsp, err = newRunner(cmds, &f, 1).ExecNode(context.Background(), "try", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "answer", rsp["default"])
+	assert.Equal(t, "", rsp["error"])
+}
+
+func TestRace(t *testing.T) {
+	f := Flow{
+		Nodes: map[string]Node{
+			"race": {
+				Id:  "race",
+				Cmd: "_race",
+				Inputs: []NodeInput{
+					{Key: "default", Type: "anchor", List: true, Anchors: []NodeAnchorTarget{
+						{NodeId: "slow", OutputKey: "default"},
+						{NodeId: "fast", OutputKey: "default"},
+					}},
+				},
+			},
+			"slow": {Id: "slow", Cmd: "slow"},
+			"fast": {Id: "fast", Cmd: "fast"},
+		},
+	}
+	core := NewWriteFlowCore()
+	core.RegisterCmd("slow", NewFun(func(ctx context.Context, params map[string]interface{}) (rsp map[string]interface{}, err error) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+			return map[string]interface{}{"default": "slow"}, nil
+		}
+	}))
+	core.RegisterCmd("fast", NewFun(func(ctx context.Context, params map[string]interface{}) (rsp map[string]interface{}, err error) {
+		time.Sleep(5 * time.Millisecond)
+		return map[string]interface{}{"default": "fast"}, nil
+	}))
+
+	start := time.Now()
+	results, err := core.ExecFlowAsync(context.Background(), &f, nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := map[string]NodeStatusLog{}
+	for r := range results {
+		last[r.NodeId] = r
+	}
+
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	assert.Equal(t, StatusSuccess, last["race"].Status)
+	assert.Equal(t, "fast", last["race"].ResultRaw["default"])
+	assert.Equal(t, "fast", last["race"].ResultRaw["winner"])
+	assert.Equal(t, StatusUnreachable, last["slow"].Status)
+	assert.Equal(t, "race 'race' was won by 'fast'", last["slow"].Reason)
+}
+
+// 胜出的候选返回流时，流不会因为 race 结束而被取消
+func TestRaceStream(t *testing.T) {
+	f := Flow{
+		Nodes: map[string]Node{
+			"race": {
+				Id:  "race",
+				Cmd: "_race",
+				Inputs: []NodeInput{
+					{Key: "default", Type: "anchor", List: true, Anchors: []NodeAnchorTarget{
+						{NodeId: "slow", OutputKey: "default"},
+						{NodeId: "fast", OutputKey: "default"},
+					}},
+				},
+			},
+			"slow": {Id: "slow", Cmd: "llm", Inputs: []NodeInput{{Key: "delay", Type: "literal", Literal: "1s"}}},
+			"fast": {Id: "fast", Cmd: "llm"},
+			"out":  {Id: "out", Cmd: "_output", Inputs: []NodeInput{{Key: "default", Type: "anchor", Anchors: []NodeAnchorTarget{{NodeId: "race", OutputKey: "default"}}}}},
+		},
+		OutputNodeId: "out",
+	}
+	core := NewWriteFlowCore()
+	core.RegisterCmd("llm", NewFun(func(ctx context.Context, params map[string]interface{}) (rsp map[string]interface{}, err error) {
+		delay, _ := ToDuration(params["delay"])
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		s := NewStream()
+		go func() {
+			for _, t := range []string{"a", "b", "c"} {
+				select {
+				case <-ctx.Done():
+					s.Close(ctx.Err())
+					return
+				case <-time.After(10 * time.Millisecond):
+				}
+				s.Append(t)
+			}
+			s.Close(nil)
+		}()
+		return map[string]interface{}{"default": s}, nil
+	}))
+
+	rsp, err := core.ExecNode(context.Background(), &f, nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "abc", rsp["default"])
+}
+
+func TestCoalesce(t *testing.T) {
+	f := Flow{
+		Nodes: map[string]Node{
+			"c": {
+				Id:  "c",
+				Cmd: "_coalesce",
+				Inputs: []NodeInput{
+					{Key: "default", Type: "anchor", List: true, Anchors: []NodeAnchorTarget{
+						{NodeId: "a", OutputKey: "default"},
+						{NodeId: "b", OutputKey: "default"},
+						{NodeId: "d", OutputKey: "default"},
+					}},
+				},
+			},
+			"a": {Id: "a", Cmd: "nothing"},
+			"b": {Id: "b", Cmd: "nothing", Inputs: []NodeInput{{Key: "default", Type: "literal", Literal: "b"}}},
+			"d": {Id: "d", Cmd: "nothing", Inputs: []NodeInput{{Key: "default", Type: "literal", Literal: "d"}}},
+		},
+	}
+
+	results, err := NewWriteFlowCore().ExecFlowAsync(context.Background(), &f, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := map[string]NodeStatusLog{}
+	for r := range results {
+		last[r.NodeId] = r
+	}
+
+	assert.Equal(t, "b", last["c"].ResultRaw["default"])
+	assert.Equal(t, StatusUnreachable, last["d"].Status)
+	assert.Equal(t, "coalesce 'c' got a value from 'b'", last["d"].Reason)
+}
+
+func TestNodeLogs(t *testing.T) {
+	js, _ := NewJavaScriptCMD(`function exec(params) { console.log("a"); console.log("b", params.n); return {default: 1} }`)
+	f := Flow{
+		Nodes: map[string]Node{
+			"js": {Id: "js", Cmd: "js", BuiltCmd: js, Inputs: []NodeInput{{Key: "n", Type: "literal", Literal: 1}}},
+		},
+	}
+
+	var l sync.Mutex
+	var running [][]string
+	var final []string
+	_, err := newRunner(nil, &f, 1).ExecNode(context.Background(), "js", false, func(result NodeStatusLog) {
+		l.Lock()
+		defer l.Unlock()
+		switch result.Status {
+		case StatusRunning:
+			if len(result.Logs) != 0 {
+				running = append(running, result.Logs)
+			}
+		case StatusSuccess:
+			final = result.Logs
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, [][]string{{"a"}, {"a", "b 1"}}, running)
+	assert.Equal(t, []string{"a", "b 1"}, final)
+}
+
+func TestStreamCmd(t *testing.T) {
+	f := Flow{
+		Nodes: map[string]Node{
+			"llm":   {Id: "llm", Cmd: "llm"},
+			"upper": {Id: "upper", Cmd: "upper", Inputs: []NodeInput{{Key: "default", Type: "anchor", Anchors: []NodeAnchorTarget{{NodeId: "llm", OutputKey: "default"}}}}},
+			"output": {Id: "output", Cmd: "_output", Inputs: []NodeInput{
+				{Key: "default", Type: "anchor", Anchors: []NodeAnchorTarget{{NodeId: "upper", OutputKey: "default"}}},
+			}},
+		},
+		OutputNodeId: "output",
+	}
+
+	next := make(chan struct{})
+	cmds := map[string]CMDer{
+		"llm": NewFun(func(ctx context.Context, params map[string]interface{}) (rsp map[string]interface{}, err error) {
+			s := NewStream()
+			go func() {
+				s.Append("hello ")
+				// 下游收到第一段之后才继续输出
+				select {
+				case <-next:
+				case <-time.After(5 * time.Second):
+				}
+				s.Append("world")
+				s.Close(nil)
+			}()
+			return map[string]interface{}{"default": s}, nil
+		}),
+		"upper": NewStreamFun(func(ctx context.Context, params map[string]interface{}) (rsp map[string]interface{}, err error) {
+			return map[string]interface{}{"default": strings.ToUpper(params["default"].(string))}, nil
+		}, func(ctx context.Context, params Map) (<-chan StreamChunk, error) {
+			ch := make(chan StreamChunk)
+			go func() {
+				defer close(ch)
+				err := ForStream(ctx, params["default"], func(text string) error {
+					ch <- StreamChunk{Text: strings.ToUpper(text)}
+					return nil
+				})
+				if err != nil {
+					ch <- StreamChunk{Err: err}
+				}
+			}()
+			return ch, nil
+		}),
+	}
+
+	var once sync.Once
+	var l sync.Mutex
+	var outputs []interface{}
+	rsp, err := newRunner(cmds, &f, 1).ExecNode(context.Background(), "output", false, func(result NodeStatusLog) {
+		if result.NodeId != "output" {
+			return
+		}
+		l.Lock()
+		outputs = append(outputs, result.ResultRaw["default"])
+		l.Unlock()
+		if result.Status == StatusRunning {
+			assert.Equal(t, []string{"default"}, result.Streaming)
+		}
+		if result.ResultRaw["default"] == "HELLO " {
+			once.Do(func() { close(next) })
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "HELLO WORLD", rsp["default"])
+	assert.Equal(t, []interface{}{"HELLO ", "HELLO WORLD", "HELLO WORLD"}, outputs)
+
+	// 没有流输入时使用 Exec
+	cmds["llm"] = NewFun(func(ctx context.Context, params map[string]interface{}) (rsp map[string]interface{}, err error) {
+		return map[string]interface{}{"default": "hello"}, nil
+	})
+	rsp, err = newRunner(cmds, &f, 1).ExecNode(context.Background(), "upper", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "HELLO", rsp["default"])
+}
+
+// 流式执行同样使用 _retry、_timeout 与 panic 处理
+func TestStreamCmdPolicy(t *testing.T) {
+	upper := func(input string, extra ...NodeInput) Node {
+		return Node{Id: "upper", Cmd: "upper", Inputs: append([]NodeInput{
+			{Key: "default", Type: "anchor", Anchors: []NodeAnchorTarget{{NodeId: input, OutputKey: "default"}}},
+		}, extra...)}
+	}
+	output := Node{Id: "output", Cmd: "_output", Inputs: []NodeInput{
+		{Key: "default", Type: "anchor", Anchors: []NodeAnchorTarget{{NodeId: "upper", OutputKey: "default"}}},
+	}}
+
+	times := 0
+	cmds := map[string]CMDer{
+		"llm": NewFun(func(ctx context.Context, params map[string]interface{}) (rsp map[string]interface{}, err error) {
+			s := NewStream()
+			s.Append("a")
+			s.Close(nil)
+			return map[string]interface{}{"default": s}, nil
+		}),
+		"upper": NewStreamFun(nil, func(ctx context.Context, params Map) (<-chan StreamChunk, error) {
+			times++
+			if params["mode"] == "panic" {
+				panic("boom")
+			}
+			if params["mode"] == "flaky" && times < 2 {
+				return nil, fmt.Errorf("flaky")
+			}
+			ch := make(chan StreamChunk)
+			go func() {
+				defer close(ch)
+				_ = ForStream(ctx, params["default"], func(text string) error {
+					if params["mode"] == "slow" {
+						time.Sleep(time.Second)
+					}
+					select {
+					case ch <- StreamChunk{Text: strings.ToUpper(text)}:
+					case <-ctx.Done():
+					}
+					return nil
+				})
+			}()
+			return ch, nil
+		}),
+	}
+
+	run := func(extra ...NodeInput) (Map, []int, error) {
+		f := Flow{Nodes: map[string]Node{"llm": {Id: "llm", Cmd: "llm"}, "upper": upper("llm", extra...), "output": output}}
+		var l sync.Mutex
+		var attempts []int
+		rsp, err := newRunner(cmds, &f, 0).ExecNode(context.Background(), "output", false, func(result NodeStatusLog) {
+			l.Lock()
+			defer l.Unlock()
+			if result.NodeId == "upper" && result.Attempt != 0 {
+				attempts = append(attempts, result.Attempt)
+			}
+		})
+		return rsp, attempts, err
+	}
+
+	rsp, attempts, err := run(
+		NodeInput{Key: "mode", Type: "literal", Literal: "flaky"},
+		NodeInput{Key: "_retry", Type: "literal", Literal: "1"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "A", rsp["default"])
+	assert.Equal(t, []int{2, 2}, attempts)
+
+	_, _, err = run(NodeInput{Key: "mode", Type: "literal", Literal: "panic"})
+	assert.ErrorContains(t, err, "cmd panic: boom")
+
+	_, _, err = run(
+		NodeInput{Key: "mode", Type: "literal", Literal: "slow"},
+		NodeInput{Key: "_timeout", Type: "literal", Literal: "20ms"},
+	)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}